@@ -0,0 +1,88 @@
+package couchbasecapella
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+)
+
+const apiKeyDescription = "Dynamic API key issued by Vault"
+
+// newAPIKeyCredential creates a Capella API key named name with the roles of
+// the statement and returns its access key. The key gets the password Vault
+// generated as secret and expires with the lease.
+func newAPIKeyCredential(ctx context.Context, c *couchbaseCapellaDBConnectionProducer, name string, stmt *capellaStatement, req dbplugin.NewUserRequest) (string, error) {
+	key := CapellaAPIKeyRequest{
+		Name:              name,
+		Description:       apiKeyDescription,
+		OrganizationRoles: stmt.OrganizationRoles,
+		Resources:         stmt.Resources,
+	}
+	if len(key.OrganizationRoles) == 0 {
+		key.OrganizationRoles = []string{"organizationMember"}
+	}
+	if len(stmt.ProjectRoles) > 0 {
+		key.Resources = append(key.Resources, CapellaAPIKeyResource{ID: c.ProjectID, Type: "project", Roles: stmt.ProjectRoles})
+	}
+	if !req.Expiration.IsZero() {
+		ttl := time.Until(req.Expiration)
+		if ttl <= 0 {
+			return "", fmt.Errorf("the lease of API key %s expires before the key is created", name)
+		}
+		// Capella takes the expiry in days. Fractions of a day keep the key
+		// from outliving its lease.
+		key.Expiry = ttl.Hours() / 24
+	}
+
+	accessKey, _, err := CreateCapellaAPIKey(c.CloudAPIBaseURL, c.OrganizationID, c.Username, c.Password, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create API key %s: %w", name, err)
+	}
+
+	_, err = RotateCapellaAPIKey(c.CloudAPIBaseURL, c.OrganizationID, c.Username, c.Password, accessKey, req.Password)
+	if err != nil {
+		if delErr := DeleteCapellaAPIKey(c.CloudAPIBaseURL, c.OrganizationID, c.Username, c.Password, accessKey); delErr != nil {
+			c.logger.Error("failed to roll back API key creation", "key", accessKey, "error", delErr)
+		}
+		return "", fmt.Errorf("failed to set the secret of API key %s: %w", accessKey, err)
+	}
+
+	return accessKey, nil
+}
+
+// deleteAPIKeyCredential revokes the dynamic API key accessKey.
+func deleteAPIKeyCredential(c *couchbaseCapellaDBConnectionProducer, accessKey string) error {
+	if accessKey == c.Username {
+		return fmt.Errorf("refusing to revoke the root API key %s", accessKey)
+	}
+	err := DeleteCapellaAPIKey(c.CloudAPIBaseURL, c.OrganizationID, c.Username, c.Password, accessKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete API key %s: %w", accessKey, err)
+	}
+	return nil
+}
+
+// isAPIKeyCredential reports whether username is the access key of an API key
+// issued by the plugin. Credentials are recognised by their username, not by
+// the statements, as a role may set credential_type in its creation
+// statements only.
+func isAPIKeyCredential(c *couchbaseCapellaDBConnectionProducer, username string) (bool, error) {
+	key, err := GetCapellaAPIKey(c.CloudAPIBaseURL, c.OrganizationID, c.Username, c.Password, username)
+	var apiErr *CapellaAPIError
+	switch {
+	case err == nil && key.Description == apiKeyDescription:
+		return true, nil
+	case err == nil:
+		return false, fmt.Errorf("%s is an API key that was not issued by Vault, refusing to manage it", username)
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 &&
+		apiErr.StatusCode != http.StatusUnauthorized && apiErr.StatusCode != http.StatusTooManyRequests:
+		// Not an API key, or one the root API key cannot read and so cannot
+		// have created.
+		return false, nil
+	}
+	return false, fmt.Errorf("unable to check whether %s is an API key: %w", username, err)
+}
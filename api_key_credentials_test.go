@@ -0,0 +1,202 @@
+package couchbasecapella
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+)
+
+func TestNewAPIKeyCredential(t *testing.T) {
+	tests := map[string]struct {
+		rotateStatus int
+		wantErr      bool
+		wantDeleted  bool
+	}{
+		"created":         {rotateStatus: http.StatusOK},
+		"secret rejected": {rotateStatus: http.StatusBadRequest, wantErr: true, wantDeleted: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var created CapellaAPIKeyRequest
+			deleted := false
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPost && r.URL.Path == "/organizations/org/apikeys":
+					if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+						t.Errorf("unable to decode the API key: %s", err)
+					}
+					w.WriteHeader(http.StatusCreated)
+					fmt.Fprintf(w, `{"id":"KEY","token":%q}`, base64.StdEncoding.EncodeToString([]byte("KEY:GENERATED")))
+				case r.Method == http.MethodPost && r.URL.Path == "/organizations/org/apikeys/KEY/rotate":
+					w.WriteHeader(test.rotateStatus)
+					fmt.Fprint(w, `{"secretKey":"PASSWORD"}`)
+				case r.Method == http.MethodDelete && r.URL.Path == "/organizations/org/apikeys/KEY":
+					deleted = true
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer srv.Close()
+
+			cp := newTestConnectionProducer(srv.URL)
+			cp.logger = hclog.NewNullLogger()
+			stmt := &capellaStatement{CredentialType: credentialTypeAPIKey, ProjectRoles: []string{"projectViewer"}}
+			req := dbplugin.NewUserRequest{Password: "PASSWORD", Expiration: time.Now().Add(2 * time.Hour)}
+
+			accessKey, err := newAPIKeyCredential(context.Background(), cp, "V_TERRAFORM", stmt, req)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if deleted != test.wantDeleted {
+				t.Fatalf("expected deleted to be %t", test.wantDeleted)
+			}
+			if test.wantErr {
+				return
+			}
+			if accessKey != "KEY" {
+				t.Fatalf("expected access key KEY, got %q", accessKey)
+			}
+			if created.Name != "V_TERRAFORM" || created.Description != apiKeyDescription {
+				t.Fatalf("unexpected API key %+v", created)
+			}
+			if len(created.Resources) != 1 || created.Resources[0].ID != "proj" || created.Resources[0].Roles[0] != "projectViewer" {
+				t.Fatalf("expected the project roles to be granted on proj, got %+v", created.Resources)
+			}
+			// Two hours, not rounded up to a day.
+			if created.Expiry <= 0.08 || created.Expiry > 2.0/24 {
+				t.Fatalf("expected the key to expire with the lease, got %v days", created.Expiry)
+			}
+		})
+	}
+}
+
+func TestDeleteAPIKeyCredential(t *testing.T) {
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		deleted = append(deleted, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cp := newTestConnectionProducer(srv.URL)
+	if err := deleteAPIKeyCredential(cp, "ACCESS"); err == nil {
+		t.Fatalf("expected the root API key not to be revoked")
+	}
+	if err := deleteAPIKeyCredential(cp, "KEY"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "/organizations/org/apikeys/KEY" {
+		t.Fatalf("expected only KEY to be deleted, deleted %v", deleted)
+	}
+}
+
+func TestUpdateUser_RenewAPIKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/organizations/org/apikeys/KEY" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		fmt.Fprintf(w, `{"id":"KEY","description":%q}`, apiKeyDescription)
+	}))
+	defer srv.Close()
+
+	db := &CouchbaseCapellaDB{couchbaseCapellaDBConnectionProducer: newTestConnectionProducer(srv.URL), logger: hclog.NewNullLogger()}
+	db.couchbaseCapellaDBConnectionProducer.logger = hclog.NewNullLogger()
+
+	_, err := db.UpdateUser(context.Background(), dbplugin.UpdateUserRequest{
+		Username:   "KEY",
+		Expiration: &dbplugin.ChangeExpiration{NewExpiration: time.Now().Add(24 * time.Hour)},
+	})
+	if err == nil || !strings.Contains(err.Error(), "cannot be renewed") {
+		t.Fatalf("expected the renewal of an API key to be rejected, got %v", err)
+	}
+}
+
+func TestDeleteUser_CredentialType(t *testing.T) {
+	tests := map[string]struct {
+		username    string
+		apiKey      string
+		wantDeleted string
+		wantErr     string
+	}{
+		"api key without statements": {
+			username:    "KEY",
+			apiKey:      fmt.Sprintf(`{"id":"KEY","description":%q}`, apiKeyDescription),
+			wantDeleted: "/organizations/org/apikeys/KEY",
+		},
+		"api key not issued by vault": {
+			username: "KEY",
+			apiKey:   `{"id":"KEY","description":"terraform"}`,
+			wantErr:  "not issued by Vault",
+		},
+		"database credential": {
+			username:    "V_USER",
+			wantDeleted: "/organizations/org/projects/proj/clusters/cluster/users/user-id",
+		},
+		// Already deleted, for example by bulk-revoke.
+		"not found anywhere": {
+			username: "V_GONE",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var deleted []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/organizations/org/apikeys/"):
+					if test.apiKey == "" {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					fmt.Fprint(w, test.apiKey)
+				case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/allowedcidrs"):
+					fmt.Fprint(w, `{"data":[]}`)
+				case r.Method == http.MethodGet && r.URL.Path == "/organizations/org/appservices":
+					fmt.Fprint(w, `{"data":[]}`)
+				case r.Method == http.MethodGet && r.URL.Path == "/organizations/org/projects/proj/clusters":
+					fmt.Fprint(w, `{"data":[{"id":"cluster","name":"c1"}]}`)
+				case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/users"):
+					fmt.Fprint(w, `{"data":[{"id":"user-id","name":"V_USER"}],"cursor":{"pages":{"page":1}}}`)
+				case r.Method == http.MethodDelete:
+					deleted = append(deleted, r.URL.Path)
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer srv.Close()
+
+			// A project level configuration, so the user is searched for on
+			// every cluster of the project.
+			cp := newTestConnectionProducer(srv.URL)
+			cp.ClusterID = ""
+			cp.clusterIDs = nil
+			db := &CouchbaseCapellaDB{couchbaseCapellaDBConnectionProducer: cp, logger: hclog.NewNullLogger()}
+
+			_, err := db.DeleteUser(context.Background(), dbplugin.DeleteUserRequest{Username: test.username})
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("expected an error containing %q, got %v", test.wantErr, err)
+			}
+			if strings.Join(deleted, ",") != test.wantDeleted {
+				t.Fatalf("expected %q to be deleted, deleted %v", test.wantDeleted, deleted)
+			}
+		})
+	}
+}
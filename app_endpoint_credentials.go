@@ -0,0 +1,145 @@
+package couchbasecapella
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+)
+
+// appEndpointCluster returns the cluster the App Service of an
+// app_endpoint_user statement is linked to.
+func appEndpointCluster(c *couchbaseCapellaDBConnectionProducer, stmt *capellaStatement) (string, error) {
+	clusterIDs, err := c.targetClusters(stmt)
+	if err != nil {
+		return "", err
+	}
+	if len(clusterIDs) != 1 {
+		return "", fmt.Errorf("%s credentials need exactly one cluster, got %d", credentialTypeAppEndpointUser, len(clusterIDs))
+	}
+	return clusterIDs[0], nil
+}
+
+// newAppEndpointUser creates username on the App Endpoint named by the
+// statement, with the admin channels and roles of the statement.
+func newAppEndpointUser(ctx context.Context, c *couchbaseCapellaDBConnectionProducer, username string, stmt *capellaStatement, req dbplugin.NewUserRequest) error {
+	clusterID, err := appEndpointCluster(c, stmt)
+	if err != nil {
+		return err
+	}
+	err = c.ensureClusterHealthy(ctx, c.clustersPath(clusterID))
+	if err != nil {
+		return err
+	}
+
+	user := AppEndpointUser{
+		Name:          username,
+		Password:      req.Password,
+		AdminChannels: stmt.AdminChannels,
+		AdminRoles:    stmt.Roles,
+	}
+	err = CreateAppEndpointUser(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password, stmt.AppServiceID, stmt.AppEndpoint, user)
+	if err != nil {
+		return fmt.Errorf("failed to create app endpoint user %s on %s: %w", username, stmt.AppEndpoint, err)
+	}
+	return nil
+}
+
+// updateAppEndpointUserPassword sets the password of username, keeping its
+// admin channels and roles.
+func updateAppEndpointUserPassword(c *couchbaseCapellaDBConnectionProducer, stmt *capellaStatement, username, password string) error {
+	clusterID, err := appEndpointCluster(c, stmt)
+	if err != nil {
+		return err
+	}
+
+	user, err := GetAppEndpointUser(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password, stmt.AppServiceID, stmt.AppEndpoint, username)
+	if err != nil {
+		return fmt.Errorf("failed to read app endpoint user %s on %s: %w", username, stmt.AppEndpoint, err)
+	}
+	user.Name = username
+	user.Password = password
+	err = UpdateAppEndpointUser(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password, stmt.AppServiceID, stmt.AppEndpoint, *user)
+	if err != nil {
+		return fmt.Errorf("failed to update app endpoint user %s on %s: %w", username, stmt.AppEndpoint, err)
+	}
+	return nil
+}
+
+// deleteAppEndpointUser deletes username from the App Endpoint named by the statement.
+func deleteAppEndpointUser(c *couchbaseCapellaDBConnectionProducer, stmt *capellaStatement, username string) error {
+	clusterID, err := appEndpointCluster(c, stmt)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteAppEndpointUser(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password, stmt.AppServiceID, stmt.AppEndpoint, username)
+	var apiErr *CapellaAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		c.logger.Info("app endpoint user to revoke was already deleted", "user", username, "app_endpoint", stmt.AppEndpoint)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete app endpoint user %s on %s: %w", username, stmt.AppEndpoint, err)
+	}
+	return nil
+}
+
+// appEndpointUserLocation is an App Endpoint a user was found on.
+type appEndpointUserLocation struct {
+	clusterID    string
+	appServiceID string
+	appEndpoint  string
+}
+
+// findAppEndpointUser searches the App Endpoints of the clusters the
+// statement can target for username. Revocation and rotation statements then
+// need not name the App Endpoint of the user.
+func findAppEndpointUser(c *couchbaseCapellaDBConnectionProducer, stmt *capellaStatement, username string) ([]appEndpointUserLocation, error) {
+	clusterIDs, err := c.searchClusters(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := ListCapellaAppServices(c.CloudAPIBaseURL, c.OrganizationID, c.Username, c.Password)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the app services of organization %s: %w", c.OrganizationID, err)
+	}
+
+	var locations []appEndpointUserLocation
+	for _, service := range services {
+		if !strutil.StrListContains(clusterIDs, service.ClusterID) {
+			continue
+		}
+		clustersPath := c.clustersPath(service.ClusterID)
+		endpoints, err := ListAppEndpoints(c.CloudAPIBaseURL, clustersPath, c.Username, c.Password, service.ID)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the app endpoints of app service %s: %w", service.ID, err)
+		}
+		for _, endpoint := range endpoints {
+			_, err := GetAppEndpointUser(c.CloudAPIBaseURL, clustersPath, c.Username, c.Password, service.ID, endpoint.Name, username)
+			var apiErr *CapellaAPIError
+			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to look up app endpoint user %s on %s: %w", username, endpoint.Name, err)
+			}
+			locations = append(locations, appEndpointUserLocation{clusterID: service.ClusterID, appServiceID: service.ID, appEndpoint: endpoint.Name})
+		}
+	}
+	return locations, nil
+}
+
+// statement returns the statement of an App Endpoint user found at l.
+func (l appEndpointUserLocation) statement() *capellaStatement {
+	return &capellaStatement{
+		CredentialType: credentialTypeAppEndpointUser,
+		Cluster:        l.clusterID,
+		AppServiceID:   l.appServiceID,
+		AppEndpoint:    l.appEndpoint,
+	}
+}
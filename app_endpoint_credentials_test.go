@@ -0,0 +1,144 @@
+package couchbasecapella
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+)
+
+const testAppEndpointUsersPath = "/organizations/org/projects/proj/clusters/cluster/appservices/app/appEndpoints/mobile/users"
+
+// newAppEndpointTestServer serves an App Service app on cluster with the App
+// Endpoint mobile, holding the App Endpoint users in users.
+func newAppEndpointTestServer(t *testing.T, users map[string]AppEndpointUser) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, testAppEndpointUsersPath+"/")
+		switch {
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/organizations/org/apikeys/"):
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet && r.URL.Path == "/organizations/org/projects/proj/clusters/cluster":
+			fmt.Fprint(w, `{"id":"cluster","name":"c1","currentState":"healthy"}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/organizations/org/projects/proj/clusters/cluster/users":
+			fmt.Fprint(w, `{"data":[],"cursor":{"pages":{"page":1}}}`)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/allowedcidrs"):
+			fmt.Fprint(w, `{"data":[]}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/organizations/org/appservices":
+			fmt.Fprint(w, `{"data":[{"id":"other","clusterId":"other-cluster"},{"id":"app","clusterId":"cluster"}]}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/organizations/org/projects/proj/clusters/cluster/appservices/app/appEndpoints":
+			fmt.Fprint(w, `{"data":[{"name":"mobile"}]}`)
+		case r.Method == http.MethodPost && r.URL.Path == testAppEndpointUsersPath:
+			var user AppEndpointUser
+			if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+				t.Errorf("unable to decode the user: %s", err)
+			}
+			users[user.Name] = user
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, testAppEndpointUsersPath+"/"):
+			user, ok := users[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			user.Password = ""
+			json.NewEncoder(w).Encode(user)
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, testAppEndpointUsersPath+"/"):
+			var user AppEndpointUser
+			if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+				t.Errorf("unable to decode the user: %s", err)
+			}
+			users[name] = user
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, testAppEndpointUsersPath+"/"):
+			if _, ok := users[name]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(users, name)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+}
+
+func TestAppEndpointUser_Lifecycle(t *testing.T) {
+	users := map[string]AppEndpointUser{}
+	srv := newAppEndpointTestServer(t, users)
+	defer srv.Close()
+
+	cp := newTestConnectionProducer(srv.URL)
+	cp.logger = hclog.NewNullLogger()
+	stmt, err := parseStatement(`{"credential_type":"app_endpoint_user","app_service_id":"app","app_endpoint":"mobile","admin_channels":["orders"],"roles":["reader"]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err = newAppEndpointUser(context.Background(), cp, "V_MOBILE", stmt, dbplugin.NewUserRequest{Password: "first"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	user := users["V_MOBILE"]
+	if user.Password != "first" || strings.Join(user.AdminChannels, ",") != "orders" || strings.Join(user.AdminRoles, ",") != "reader" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	err = updateAppEndpointUserPassword(cp, stmt, "V_MOBILE", "second")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	user = users["V_MOBILE"]
+	if user.Password != "second" || strings.Join(user.AdminChannels, ",") != "orders" || strings.Join(user.AdminRoles, ",") != "reader" {
+		t.Fatalf("expected only the password to change, got %+v", user)
+	}
+
+	err = deleteAppEndpointUser(cp, stmt, "V_MOBILE")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := users["V_MOBILE"]; ok {
+		t.Fatalf("expected the user to be deleted")
+	}
+}
+
+func TestAppEndpointUser_WithoutStatements(t *testing.T) {
+	users := map[string]AppEndpointUser{
+		"V_MOBILE": {Name: "V_MOBILE", Password: "first", AdminChannels: []string{"orders"}},
+	}
+	srv := newAppEndpointTestServer(t, users)
+	defer srv.Close()
+
+	db := &CouchbaseCapellaDB{couchbaseCapellaDBConnectionProducer: newTestConnectionProducer(srv.URL), logger: hclog.NewNullLogger()}
+	db.couchbaseCapellaDBConnectionProducer.logger = hclog.NewNullLogger()
+
+	_, err := db.UpdateUser(context.Background(), dbplugin.UpdateUserRequest{
+		Username: "V_MOBILE",
+		Password: &dbplugin.ChangePassword{NewPassword: "second"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if users["V_MOBILE"].Password != "second" {
+		t.Fatalf("expected the password to be updated, got %+v", users["V_MOBILE"])
+	}
+
+	_, err = db.DeleteUser(context.Background(), dbplugin.DeleteUserRequest{Username: "V_MOBILE"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := users["V_MOBILE"]; ok {
+		t.Fatalf("expected the user to be deleted")
+	}
+
+	// A user found nowhere was already deleted.
+	_, err = db.DeleteUser(context.Background(), dbplugin.DeleteUserRequest{Username: "V_MOBILE"})
+	if err != nil {
+		t.Fatalf("expected a user found nowhere to count as revoked, got %v", err)
+	}
+}
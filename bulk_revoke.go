@@ -0,0 +1,190 @@
+package couchbasecapella
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/database/helper/connutil"
+)
+
+const defaultBulkRevokeConcurrency = 4
+
+// BulkRevokeOptions configures BulkRevoke.
+type BulkRevokeOptions struct {
+	// Pattern is a glob over usernames, or a regular expression if Regexp
+	// is set. Both are matched case insensitively.
+	Pattern string
+	Regexp  bool
+
+	// Execute deletes the matching users. Without it BulkRevoke only
+	// reports them.
+	Execute bool
+
+	// Concurrency bounds the deletions in flight, 4 if not set.
+	Concurrency int
+}
+
+// RevokedUser is a user matched by BulkRevoke.
+type RevokedUser struct {
+	ClusterID string `json:"cluster_id,omitempty"`
+	Username  string `json:"username"`
+	Deleted   bool   `json:"deleted"`
+	Error     string `json:"error,omitempty"`
+
+	id string
+}
+
+// BulkRevokeReport is the result of BulkRevoke.
+type BulkRevokeReport struct {
+	Pattern string        `json:"pattern"`
+	DryRun  bool          `json:"dry_run"`
+	Users   []RevokedUser `json:"users"`
+	Failed  int           `json:"failed"`
+}
+
+// BulkRevoke deletes, in an emergency, every database credential whose
+// username matches a pattern, regardless of the leases Vault holds for them.
+// Only usernames of the shape the username template generates are matched,
+// so users Vault did not issue are never touched. It defaults to a dry run
+// that only reports the matching users.
+func (c *CouchbaseCapellaDB) BulkRevoke(ctx context.Context, opts BulkRevokeOptions) (*BulkRevokeReport, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if !c.Initialized {
+		return nil, connutil.ErrNotInitialized
+	}
+	match, err := usernameMatcher(opts.Pattern, opts.Regexp)
+	if err != nil {
+		return nil, err
+	}
+	shape, err := c.usernameShape()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultBulkRevokeConcurrency
+	}
+	err = c.checkKeyExpiry()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BulkRevokeReport{Pattern: opts.Pattern, DryRun: !opts.Execute}
+	report.Users, err = c.matchingUsers(func(username string) bool {
+		return shape.MatchString(username) && match(username)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Execute {
+		return report, nil
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, opts.Concurrency)
+	for i := range report.Users {
+		user := &report.Users[i]
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			user.Error = ctx.Err().Error()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := c.revokeMatchedUser(user); err != nil {
+				user.Error = err.Error()
+				c.logger.Error("bulk revocation failed", "user", user.Username, "cluster", user.ClusterID, "error", err)
+				return
+			}
+			user.Deleted = true
+			c.logger.Warn("bulk revoked user", "user", user.Username, "cluster", user.ClusterID)
+		}()
+	}
+	wg.Wait()
+
+	for _, user := range report.Users {
+		if user.Error != "" {
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// usernameMatcher compiles a case insensitive glob or regular expression over
+// usernames.
+func usernameMatcher(pattern string, isRegexp bool) (func(string) bool, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("a username pattern is required")
+	}
+	if isRegexp {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid username regular expression: %w", err)
+		}
+		return re.MatchString, nil
+	}
+
+	pattern = strings.ToUpper(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid username glob: %w", err)
+	}
+	return func(username string) bool {
+		ok, _ := path.Match(pattern, strings.ToUpper(username))
+		return ok
+	}, nil
+}
+
+// matchingUsers lists the users of the managed clusters that match.
+func (c *CouchbaseCapellaDB) matchingUsers(match func(string) bool) ([]RevokedUser, error) {
+	var matched []RevokedUser
+	if c.Deployment == deploymentSelfManaged {
+		client, err := c.restClient()
+		if err != nil {
+			return nil, err
+		}
+		users, err := client.ListRBACUsers()
+		if err != nil {
+			return nil, fmt.Errorf("unable to list users: %w", err)
+		}
+		for _, user := range users {
+			if match(user.ID) {
+				matched = append(matched, RevokedUser{Username: user.ID})
+			}
+		}
+		return matched, nil
+	}
+
+	clusterIDs, err := c.managedClusters()
+	if err != nil {
+		return nil, err
+	}
+	for _, clusterID := range clusterIDs {
+		users, err := ListCapellaDbCredUsers(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the users of cluster %s: %w", clusterID, err)
+		}
+		for _, user := range users {
+			if match(user.Name) {
+				matched = append(matched, RevokedUser{ClusterID: clusterID, Username: user.Name, id: user.ID})
+			}
+		}
+	}
+	return matched, nil
+}
+
+// revokeMatchedUser deletes a user found by matchingUsers.
+func (c *CouchbaseCapellaDB) revokeMatchedUser(user *RevokedUser) error {
+	if c.Deployment == deploymentSelfManaged {
+		return deleteSelfManagedUser(c.couchbaseCapellaDBConnectionProducer, user.Username)
+	}
+	return DeleteCapellaDbCredUserByID(c.CloudAPIBaseURL, c.clustersPath(user.ClusterID), c.Username, c.Password, user.id)
+}
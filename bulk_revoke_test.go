@@ -0,0 +1,89 @@
+package couchbasecapella
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestCouchbaseCapellaDB_BulkRevoke(t *testing.T) {
+	users := `{"data":[
+		{"id":"1","name":"V_ALICE_ORDERS_ABCDEFGHIJ0123456789_1692391706"},
+		{"id":"2","name":"V_ALICE_BILLING_DEFGHIJKLM0123456789_1692391707"},
+		{"id":"3","name":"V_BOB_ORDERS_GHIJKLMNOP0123456789_1692391708"},
+		{"id":"4","name":"V_ALICE_ADMIN"}],"cursor":{"pages":{"page":1}}}`
+
+	tests := map[string]struct {
+		opts        BulkRevokeOptions
+		wantMatched int
+		wantDeleted []string
+		wantErr     bool
+	}{
+		"dry run":         {opts: BulkRevokeOptions{Pattern: "v_alice_*"}, wantMatched: 2},
+		"glob":            {opts: BulkRevokeOptions{Pattern: "V_ALICE_*", Execute: true}, wantMatched: 2, wantDeleted: []string{"1", "2"}},
+		"regexp":          {opts: BulkRevokeOptions{Pattern: "^V_[A-Z]+_ORDERS_", Regexp: true, Execute: true, Concurrency: 1}, wantMatched: 2, wantDeleted: []string{"1", "3"}},
+		"regexp any case": {opts: BulkRevokeOptions{Pattern: "^v_alice_", Regexp: true}, wantMatched: 2},
+		"not issued":      {opts: BulkRevokeOptions{Pattern: "V_ALICE_ADMIN", Execute: true}},
+		"no match":        {opts: BulkRevokeOptions{Pattern: "V_CAROL_*", Execute: true}},
+		"empty pattern":   {opts: BulkRevokeOptions{}, wantErr: true},
+		"invalid regexp":  {opts: BulkRevokeOptions{Pattern: "(", Regexp: true}, wantErr: true},
+		"invalid pattern": {opts: BulkRevokeOptions{Pattern: "["}, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var mu sync.Mutex
+			var deleted []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				const usersPath = "/organizations/org/projects/proj/clusters/cluster/users"
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == usersPath:
+					fmt.Fprint(w, users)
+				case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, usersPath+"/"):
+					mu.Lock()
+					deleted = append(deleted, strings.TrimPrefix(r.URL.Path, usersPath+"/"))
+					mu.Unlock()
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer srv.Close()
+
+			cp := newTestConnectionProducer(srv.URL)
+			cp.Initialized = true
+			cp.logger = hclog.NewNullLogger()
+			db := &CouchbaseCapellaDB{
+				couchbaseCapellaDBConnectionProducer: cp,
+				usernameTemplate:                     defaultUserNameTemplate,
+				usernameRules:                        usernameRules{caseMode: usernameCaseUpper, replacement: defaultUsernameReplacement, collapse: true},
+				logger:                               hclog.NewNullLogger(),
+			}
+
+			report, err := db.BulkRevoke(context.Background(), test.opts)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if test.wantErr {
+				return
+			}
+			if len(report.Users) != test.wantMatched {
+				t.Fatalf("expected %d matches, got %+v", test.wantMatched, report.Users)
+			}
+			if report.DryRun != !test.opts.Execute || report.Failed != 0 {
+				t.Fatalf("unexpected report %+v", report)
+			}
+			sort.Strings(deleted)
+			if fmt.Sprint(deleted) != fmt.Sprint(test.wantDeleted) {
+				t.Fatalf("expected deleted %v, got %v", test.wantDeleted, deleted)
+			}
+		})
+	}
+}
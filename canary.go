@@ -0,0 +1,108 @@
+package couchbasecapella
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+const (
+	canaryOperationRead   = "read"
+	canaryOperationUpsert = "upsert"
+
+	canaryExpectAllowed = "allowed"
+	canaryExpectDenied  = "denied"
+
+	canaryScopeDefault = "_default"
+)
+
+// canaryProbe is a document operation run as a new user to check that the
+// user has, or has not, the access the role intends.
+type canaryProbe struct {
+	Bucket     string `json:"bucket"`
+	Scope      string `json:"scope"`
+	Collection string `json:"collection"`
+	Key        string `json:"key"`
+	Operation  string `json:"operation"`
+	Expect     string `json:"expect"`
+}
+
+// validate checks the probe and fills in the defaults.
+func (p *canaryProbe) validate() error {
+	if p.Bucket == "" || p.Key == "" {
+		return fmt.Errorf("canary requires bucket and key")
+	}
+	if p.Scope == "" {
+		p.Scope = canaryScopeDefault
+	}
+	if p.Collection == "" {
+		p.Collection = canaryScopeDefault
+	}
+	switch p.Operation {
+	case "":
+		p.Operation = canaryOperationRead
+	case canaryOperationRead, canaryOperationUpsert:
+	default:
+		return fmt.Errorf("canary operation must be %q or %q", canaryOperationRead, canaryOperationUpsert)
+	}
+	switch p.Expect {
+	case "":
+		p.Expect = canaryExpectAllowed
+	case canaryExpectAllowed, canaryExpectDenied:
+	default:
+		return fmt.Errorf("canary expect must be %q or %q", canaryExpectAllowed, canaryExpectDenied)
+	}
+	return nil
+}
+
+// String describes the probe for errors and logs.
+func (p *canaryProbe) String() string {
+	return fmt.Sprintf("%s of %s.%s.%s/%s", p.Operation, p.Bucket, p.Scope, p.Collection, p.Key)
+}
+
+// canaryOperation runs the document operation of probe as username on
+// connStr and reports whether the server denied it. The connection waits for
+// the cluster only: the role may not be allowed to open bucket_name, and the
+// probe bucket is opened by the operation itself.
+var canaryOperation = func(ctx context.Context, c *couchbaseCapellaDBConnectionProducer, connStr, username, password string, probe *canaryProbe) (bool, error) {
+	cluster, err := c.connectTo(ctx, connStr, "", username, password, computeTimeout(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer cluster.Close(nil)
+
+	collection := cluster.Bucket(probe.Bucket).Scope(probe.Scope).Collection(probe.Collection)
+	switch probe.Operation {
+	case canaryOperationUpsert:
+		_, err = collection.Upsert(probe.Key, map[string]interface{}{"vault_canary": time.Now().Unix()},
+			&gocb.UpsertOptions{Timeout: computeTimeout(ctx)})
+	default:
+		_, err = collection.Get(probe.Key, &gocb.GetOptions{Timeout: computeTimeout(ctx)})
+		if errors.Is(err, gocb.ErrDocumentNotFound) {
+			err = nil
+		}
+	}
+	if errors.Is(err, gocb.ErrAuthenticationFailure) {
+		return true, nil
+	}
+	return false, err
+}
+
+// runCanary runs the probe as username on connStr and fails when the outcome
+// does not match what the probe expects. A missing document counts as allowed
+// for reads, since the server only reports it to users that may read.
+func (c *couchbaseCapellaDBConnectionProducer) runCanary(ctx context.Context, connStr, username, password string, probe *canaryProbe) error {
+	denied, err := canaryOperation(ctx, c, connStr, username, password, probe)
+	switch {
+	case err != nil:
+		return fmt.Errorf("canary %s failed on %s: %w", probe, connStr, err)
+	case denied && probe.Expect == canaryExpectAllowed:
+		return fmt.Errorf("canary %s was denied to user %s, check the access statement of the role", probe, username)
+	case !denied && probe.Expect == canaryExpectDenied:
+		return fmt.Errorf("canary %s was allowed to user %s, check the access statement of the role", probe, username)
+	}
+	return nil
+}
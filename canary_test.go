@@ -0,0 +1,120 @@
+package couchbasecapella
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+)
+
+// stubCanaryOperation replaces the document operation of the canary for the
+// duration of the test.
+func stubCanaryOperation(t *testing.T, op func(probe *canaryProbe) (bool, error)) {
+	previous := canaryOperation
+	canaryOperation = func(_ context.Context, _ *couchbaseCapellaDBConnectionProducer, _, _, _ string, probe *canaryProbe) (bool, error) {
+		return op(probe)
+	}
+	t.Cleanup(func() { canaryOperation = previous })
+}
+
+func TestRunCanary(t *testing.T) {
+	tests := map[string]struct {
+		expect  string
+		denied  bool
+		opErr   error
+		wantErr string
+	}{
+		"allowed as expected":  {expect: canaryExpectAllowed},
+		"denied as expected":   {expect: canaryExpectDenied, denied: true},
+		"unexpectedly denied":  {expect: canaryExpectAllowed, denied: true, wantErr: "was denied to user V_USER"},
+		"unexpectedly allowed": {expect: canaryExpectDenied, wantErr: "was allowed to user V_USER"},
+		"operation failed":     {expect: canaryExpectAllowed, opErr: errors.New("timeout"), wantErr: "failed on couchbases://cb.example.com"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			stubCanaryOperation(t, func(*canaryProbe) (bool, error) {
+				return test.denied, test.opErr
+			})
+
+			probe := &canaryProbe{Bucket: "orders", Key: "vault-canary", Expect: test.expect}
+			if err := probe.validate(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			cp := newTestConnectionProducer("")
+			err := cp.runCanary(context.Background(), "couchbases://cb.example.com", "V_USER", "PASSWORD", probe)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case test.wantErr != "" && err == nil:
+				t.Fatalf("expected error containing %q", test.wantErr)
+			case test.wantErr != "" && !strings.Contains(err.Error(), test.wantErr):
+				t.Fatalf("expected error containing %q, got %q", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewUser_CanaryRollsBack(t *testing.T) {
+	tests := map[string]struct {
+		denied      bool
+		wantDeleted bool
+	}{
+		"canary passes": {},
+		"canary fails":  {denied: true, wantDeleted: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			deleted := false
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/users"):
+					w.Write([]byte(`{"data":[{"id":"user-id","name":"V_USER"}],"cursor":{"pages":{"page":1}}}`))
+				case r.Method == http.MethodGet && r.URL.Path == "/organizations/org/projects/proj/clusters/cluster":
+					w.Write([]byte(`{"id":"cluster","currentState":"healthy"}`))
+				case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/users"):
+					w.WriteHeader(http.StatusCreated)
+				case r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/users/user-id"):
+					deleted = true
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer srv.Close()
+
+			stubPropagationProbe(t, func(string) error { return nil })
+			var bucket string
+			stubCanaryOperation(t, func(probe *canaryProbe) (bool, error) {
+				bucket = probe.Bucket
+				return test.denied, nil
+			})
+
+			cp := newTestConnectionProducer(srv.URL)
+			cp.logger = hclog.NewNullLogger()
+			cp.Hosts = "couchbases://cb.example.com"
+			cp.BucketName = "travel-sample"
+
+			stmt := `{"access": [{"privileges": ["data_reader"], "resources": {"buckets": [{"name": "orders"}]}}], "canary": {"bucket": "orders", "key": "vault-canary"}}`
+			err := newUser(context.Background(), cp, "V_USER", dbplugin.NewUserRequest{
+				Password:   "PASSWORD",
+				Statements: dbplugin.Statements{Commands: []string{stmt}},
+			})
+			if (err != nil) != test.wantDeleted {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if deleted != test.wantDeleted {
+				t.Fatalf("expected deleted to be %t", test.wantDeleted)
+			}
+			if bucket != "orders" {
+				t.Fatalf("expected the canary to use its own bucket, got %q", bucket)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package couchbasecapella
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+)
+
+const (
+	// clusterCACacheTTL is how long a CA certificate fetched from Capella is
+	// used before it is fetched again on the next connection.
+	clusterCACacheTTL = 24 * time.Hour
+
+	// clusterCAExpiryWarning is how long before its expiry a warning is
+	// logged about the cluster CA certificate.
+	clusterCAExpiryWarning = 30 * 24 * time.Hour
+)
+
+// parseCAPin decodes a ca_pin_sha256, the hex encoded SHA-256 fingerprint of
+// a certificate with or without colons.
+func parseCAPin(raw string) ([]byte, error) {
+	pin, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(raw), ":", ""))
+	if err != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("ca_pin_sha256 must be a hex encoded SHA-256 fingerprint")
+	}
+	return pin, nil
+}
+
+// clusterCA returns the PEM encoded CA certificate for data-plane connections:
+// base64pem if set, otherwise the certificate Capella publishes for the
+// configured cluster, cached for clusterCACacheTTL.
+func (c *couchbaseCapellaDBConnectionProducer) clusterCA() ([]byte, error) {
+	if len(c.Base64Pem) > 0 {
+		ca, err := base64.StdEncoding.DecodeString(c.Base64Pem)
+		if err != nil {
+			return nil, errwrap.Wrapf("error decoding Base64Pem: {{err}}", err)
+		}
+		return ca, nil
+	}
+
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	if c.caPEM != nil && time.Since(c.caFetchedAt) < clusterCACacheTTL {
+		return c.caPEM, nil
+	}
+	if len(c.clusterIDs) != 1 {
+		return nil, fmt.Errorf("base64pem is not set and no single cluster is configured to fetch the certificate from")
+	}
+
+	clusterID := c.clusterIDs[0]
+	ca, err := GetCapellaClusterCertificate(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch the certificate of cluster %s: %w", clusterID, err)
+	}
+	certs, err := parseCertificates([]byte(ca))
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate of cluster %s: %w", clusterID, err)
+	}
+	if len(c.CAPinSHA256) > 0 {
+		if err := checkCAPin(certs, c.CAPinSHA256); err != nil {
+			return nil, fmt.Errorf("certificate of cluster %s: %w", clusterID, err)
+		}
+	}
+	for _, cert := range certs {
+		if time.Until(cert.NotAfter) < clusterCAExpiryWarning {
+			c.logger.Warn("the cluster CA certificate expires soon", "cluster", clusterID,
+				"subject", cert.Subject.String(), "expires_at", cert.NotAfter)
+		}
+	}
+
+	c.caPEM = []byte(ca)
+	c.caFetchedAt = time.Now()
+	return c.caPEM, nil
+}
+
+// parseCertificates decodes every certificate of a PEM bundle.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return certs, nil
+}
+
+// checkCAPin checks that one of certs has the SHA-256 fingerprint pin.
+func checkCAPin(certs []*x509.Certificate, pin string) error {
+	want, err := parseCAPin(pin)
+	if err != nil {
+		return err
+	}
+	for _, cert := range certs {
+		got := sha256.Sum256(cert.Raw)
+		if bytes.Equal(got[:], want) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no certificate matches ca_pin_sha256")
+}
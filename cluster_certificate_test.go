@@ -0,0 +1,111 @@
+package couchbasecapella
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func testCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Couchbase Server"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(der)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), hex.EncodeToString(sum[:])
+}
+
+func TestConnectionProducer_ClusterCA(t *testing.T) {
+	cert, fingerprint := testCertificate(t)
+
+	tests := map[string]struct {
+		pin     string
+		wantErr bool
+	}{
+		"no pin":       {},
+		"pin":          {pin: fingerprint},
+		"wrong pin":    {pin: "00" + fingerprint[2:], wantErr: true},
+		"invalid pin":  {pin: "nothex", wantErr: true},
+		"colon format": {pin: fingerprint[:2] + ":" + fingerprint[2:]},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet || r.URL.Path != "/organizations/org/projects/proj/clusters/cluster/certificates" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				requests++
+				json.NewEncoder(w).Encode(map[string]string{"certificate": cert})
+			}))
+			defer srv.Close()
+
+			cp := newTestConnectionProducer(srv.URL)
+			cp.logger = hclog.NewNullLogger()
+			cp.CAPinSHA256 = test.pin
+
+			ca, err := cp.clusterCA()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if test.wantErr {
+				return
+			}
+			if string(ca) != cert {
+				t.Fatalf("unexpected certificate %q", ca)
+			}
+
+			if _, err := cp.clusterCA(); err != nil {
+				t.Fatal(err)
+			}
+			if requests != 1 {
+				t.Fatalf("expected the certificate to be cached, got %d requests", requests)
+			}
+		})
+	}
+}
+
+func TestConnectionProducer_ClusterCAUnverifiedServer(t *testing.T) {
+	cert, _ := testCertificate(t)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"certificate": cert})
+	}))
+	defer srv.Close()
+
+	cp := newTestConnectionProducer(srv.URL)
+	cp.logger = hclog.NewNullLogger()
+
+	// The management API presents a certificate that is not trusted, so the
+	// CA it serves must not be trusted either.
+	if _, err := cp.clusterCA(); err == nil {
+		t.Fatalf("expected the certificate of the management API to be verified")
+	}
+}
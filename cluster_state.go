@@ -0,0 +1,185 @@
+package couchbasecapella
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	clusterStateHealthy   = "healthy"
+	clusterStateTurnedOff = "turnedOff"
+
+	clusterStateCacheTTL = 10 * time.Second
+	defaultWakeTimeout   = 15 * time.Minute
+)
+
+// clusterStatePollInterval is how often wait_for_healthy polls the cluster.
+var clusterStatePollInterval = 5 * time.Second
+
+// clusterStateDescriptions maps Capella cluster states to the wording used in errors.
+var clusterStateDescriptions = map[string]string{
+	"draft":            "a draft and has not been deployed",
+	"deploying":        "still deploying",
+	"deploymentFailed": "failed to deploy",
+	"scaling":          "scaling",
+	"scaleFailed":      "in a failed scaling state",
+	"rebalancing":      "rebalancing",
+	"rebalanceFailed":  "in a failed rebalance state",
+	"upgrading":        "upgrading",
+	"upgradeFailed":    "in a failed upgrade state",
+	"peering":          "peering",
+	"peeringFailed":    "in a failed peering state",
+	"degraded":         "degraded",
+	"destroying":       "being destroyed",
+	"destroyFailed":    "in a failed destroy state",
+	"turningOff":       "turning off",
+	"turnedOff":        "turned off",
+	"turningOn":        "turning on",
+	"turnOnFailed":     "failed to turn on",
+}
+
+// transitionalClusterStates are the states a cluster leaves on its own, and
+// therefore the only ones wait_for_healthy waits on.
+var transitionalClusterStates = map[string]bool{
+	"deploying":   true,
+	"scaling":     true,
+	"rebalancing": true,
+	"upgrading":   true,
+	"peering":     true,
+	"turningOn":   true,
+}
+
+// ClusterStateError is returned when a credential operation targets a Capella
+// cluster that is not healthy.
+type ClusterStateError struct {
+	ClusterID string
+	Name      string
+	State     string
+}
+
+func (e *ClusterStateError) Error() string {
+	desc, ok := clusterStateDescriptions[e.State]
+	if !ok {
+		desc = fmt.Sprintf("in state %q", e.State)
+	}
+	name := e.ClusterID
+	if e.Name != "" {
+		name = fmt.Sprintf("%s (%s)", e.Name, e.ClusterID)
+	}
+	return fmt.Sprintf("cluster %s is %s", name, desc)
+}
+
+type cachedCluster struct {
+	cluster   *CapellaCluster
+	fetchedAt time.Time
+}
+
+// clusterState returns the cluster behind clustersPath, served from a short
+// lived cache unless refresh is set.
+func (c *couchbaseCapellaDBConnectionProducer) clusterState(clustersPath string, refresh bool) (*CapellaCluster, error) {
+	c.stateLock.Lock()
+	cached, ok := c.clusterStates[clustersPath]
+	c.stateLock.Unlock()
+	if ok && !refresh && time.Since(cached.fetchedAt) < clusterStateCacheTTL {
+		return cached.cluster, nil
+	}
+
+	// The cluster is read without holding stateLock, so that a slow request
+	// does not hold up the requests for other clusters.
+	cluster, err := GetCapellaCluster(c.CloudAPIBaseURL, clustersPath, c.Username, c.Password)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the cluster state: %w", err)
+	}
+
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	if c.clusterStates == nil {
+		c.clusterStates = make(map[string]cachedCluster)
+	}
+	c.clusterStates[clustersPath] = cachedCluster{cluster: cluster, fetchedAt: time.Now()}
+	return cluster, nil
+}
+
+// ensureClusterHealthy returns a *ClusterStateError unless the cluster behind
+// clustersPath is healthy. With wait_for_healthy set it polls a cluster in a
+// transitional state until it becomes healthy or the context deadline passes,
+// and with wake_cluster set it turns on a cluster that is turned off.
+func (c *couchbaseCapellaDBConnectionProducer) ensureClusterHealthy(ctx context.Context, clustersPath string) error {
+	cluster, err := c.clusterState(clustersPath, false)
+	if err != nil {
+		return err
+	}
+	if cluster.CurrentState == clusterStateHealthy {
+		return nil
+	}
+	if cluster.CurrentState == clusterStateTurnedOff && c.WakeCluster {
+		return c.wakeCluster(ctx, clustersPath, cluster)
+	}
+	if !c.WaitForHealthy || !transitionalClusterStates[cluster.CurrentState] {
+		return &ClusterStateError{ClusterID: cluster.ID, Name: cluster.Name, State: cluster.CurrentState}
+	}
+	return c.waitForHealthy(ctx, clustersPath, computeTimeout(ctx), transitionalClusterStates)
+}
+
+// wakeCluster turns on a cluster that is turned off and waits, for at most
+// wake_timeout, until it is healthy.
+func (c *couchbaseCapellaDBConnectionProducer) wakeCluster(ctx context.Context, clustersPath string, cluster *CapellaCluster) error {
+	c.logger.Warn("turning on cluster to issue credentials", "cluster", cluster.ID, "name", cluster.Name, "timeout", c.wakeTimeout)
+
+	err := TurnOnCapellaCluster(c.CloudAPIBaseURL, clustersPath, c.Username, c.Password)
+	if err != nil {
+		// Another request may have turned the cluster on in the meantime.
+		current, stateErr := c.clusterState(clustersPath, true)
+		if stateErr != nil || current.CurrentState == clusterStateTurnedOff {
+			return fmt.Errorf("unable to turn on cluster %s: %w", cluster.ID, err)
+		}
+	}
+
+	waitStates := map[string]bool{clusterStateTurnedOff: true}
+	for state := range transitionalClusterStates {
+		waitStates[state] = true
+	}
+	err = c.waitForHealthy(ctx, clustersPath, c.wakeTimeout, waitStates)
+	if err != nil {
+		return err
+	}
+	c.logger.Info("cluster turned on", "cluster", cluster.ID, "name", cluster.Name)
+	return nil
+}
+
+// waitForHealthy polls the cluster behind clustersPath until it is healthy,
+// giving up when it reaches a state outside waitStates or when timeout or the
+// context deadline passes.
+func (c *couchbaseCapellaDBConnectionProducer) waitForHealthy(ctx context.Context, clustersPath string, timeout time.Duration, waitStates map[string]bool) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(clusterStatePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			cluster, err := c.clusterState(clustersPath, false)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("timed out waiting for the cluster to become healthy: %w",
+				&ClusterStateError{ClusterID: cluster.ID, Name: cluster.Name, State: cluster.CurrentState})
+		case <-ticker.C:
+		}
+
+		cluster, err := c.clusterState(clustersPath, true)
+		if err != nil {
+			return err
+		}
+		switch {
+		case cluster.CurrentState == clusterStateHealthy:
+			return nil
+		case !waitStates[cluster.CurrentState]:
+			return &ClusterStateError{ClusterID: cluster.ID, Name: cluster.Name, State: cluster.CurrentState}
+		}
+		c.logger.Info("waiting for cluster to become healthy", "cluster", cluster.ID, "state", cluster.CurrentState)
+	}
+}
@@ -0,0 +1,105 @@
+package couchbasecapella
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestConnectionProducer_EnsureClusterHealthy(t *testing.T) {
+	interval := clusterStatePollInterval
+	t.Cleanup(func() { clusterStatePollInterval = interval })
+	clusterStatePollInterval = 10 * time.Millisecond
+
+	tests := map[string]struct {
+		states         []string
+		waitForHealthy bool
+		wakeCluster    bool
+		wantState      string
+	}{
+		"healthy":                {states: []string{"healthy"}},
+		"scaling":                {states: []string{"scaling", "healthy"}, wantState: "scaling"},
+		"scaling and waiting":    {states: []string{"scaling", "scaling", "healthy"}, waitForHealthy: true},
+		"turned off and waiting": {states: []string{"turnedOff", "healthy"}, waitForHealthy: true, wantState: "turnedOff"},
+		"turned off and waking":  {states: []string{"turnedOff", "turnedOff", "turningOn", "healthy"}, wakeCluster: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					if !test.wakeCluster || r.URL.Path != "/organizations/org/projects/proj/clusters/cluster/activationState" {
+						t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+					}
+					w.WriteHeader(http.StatusAccepted)
+					return
+				}
+				state := test.states[len(test.states)-1]
+				if calls < len(test.states) {
+					state = test.states[calls]
+				}
+				calls++
+				fmt.Fprintf(w, `{"id":"cluster","name":"c1","currentState":%q}`, state)
+			}))
+			defer srv.Close()
+
+			cp := newTestConnectionProducer(srv.URL)
+			cp.WaitForHealthy = test.waitForHealthy
+			cp.WakeCluster = test.wakeCluster
+			cp.wakeTimeout = time.Second
+			cp.logger = hclog.NewNullLogger()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			err := cp.ensureClusterHealthy(ctx, cp.clustersPath("cluster"))
+			if test.wantState == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			var stateErr *ClusterStateError
+			if !errors.As(err, &stateErr) || stateErr.State != test.wantState {
+				t.Fatalf("expected cluster state error for %q, got %v", test.wantState, err)
+			}
+		})
+	}
+}
+
+func TestConnectionProducer_ClusterStateConcurrent(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/organizations/org/projects/proj/clusters/slow" {
+			<-release
+		}
+		fmt.Fprint(w, `{"id":"cluster","name":"c1","currentState":"healthy"}`)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	cp := newTestConnectionProducer(srv.URL)
+	go cp.clusterState(cp.clustersPath("slow"), false)
+
+	// The slow request must not hold up the state of another cluster.
+	done := make(chan error)
+	go func() {
+		_, err := cp.clusterState(cp.clustersPath("cluster"), false)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the cluster state was held up by another cluster")
+	}
+}
@@ -0,0 +1,136 @@
+package couchbasecapella
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+)
+
+const clusterListCacheTTL = time.Minute
+
+// projectClusters returns the clusters of the configured project, served from
+// a short lived cache unless refresh is set.
+func (c *couchbaseCapellaDBConnectionProducer) projectClusters(refresh bool) ([]CapellaCluster, error) {
+	c.stateLock.Lock()
+	cached, fetchedAt := c.clusterList, c.clusterListFetchedAt
+	c.stateLock.Unlock()
+	if !refresh && cached != nil && time.Since(fetchedAt) < clusterListCacheTTL {
+		return cached, nil
+	}
+
+	clusters, err := ListCapellaClusters(c.CloudAPIBaseURL, c.clustersCollectionPath(), c.Username, c.Password)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the clusters of project %s: %w", c.ProjectID, err)
+	}
+
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	c.clusterList = clusters
+	c.clusterListFetchedAt = time.Now()
+	return clusters, nil
+}
+
+// resolveClusters maps cluster IDs or cluster names of the configured project
+// to cluster IDs.
+func (c *couchbaseCapellaDBConnectionProducer) resolveClusters(selectors []string) ([]string, error) {
+	var ids []string
+	for _, selector := range selectors {
+		if strutil.StrListContains(c.clusterIDs, selector) {
+			ids = append(ids, selector)
+			continue
+		}
+
+		clusters, err := c.projectClusters(false)
+		if err != nil {
+			return nil, err
+		}
+		var matches []string
+		for _, cluster := range clusters {
+			if cluster.ID == selector {
+				matches = []string{cluster.ID}
+				break
+			}
+			if cluster.Name == selector {
+				matches = append(matches, cluster.ID)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("cluster %q was not found in project %s", selector, c.ProjectID)
+		case 1:
+			if !strutil.StrListContains(ids, matches[0]) {
+				ids = append(ids, matches[0])
+			}
+		default:
+			return nil, fmt.Errorf("cluster name %q is used by several clusters of project %s, use the cluster ID instead", selector, c.ProjectID)
+		}
+	}
+	return ids, nil
+}
+
+// targetClusters returns the clusters a statement applies to: the clusters it
+// names, or else the configured cluster_id and cluster_ids.
+func (c *couchbaseCapellaDBConnectionProducer) targetClusters(stmt *capellaStatement) ([]string, error) {
+	if selectors := stmt.clusterSelectors(); len(selectors) > 0 {
+		return c.resolveClusters(selectors)
+	}
+	if len(c.clusterIDs) > 0 {
+		return c.clusterIDs, nil
+	}
+	return nil, fmt.Errorf("no target cluster, set cluster_id or cluster_ids in the database configuration or name the cluster in the role statements")
+}
+
+// userClusters returns the clusters an existing user is managed on. Without a
+// cluster in the statement or the configuration, every cluster of the project
+// is searched for the user.
+func (c *couchbaseCapellaDBConnectionProducer) userClusters(stmt *capellaStatement, username string) ([]string, error) {
+	if len(stmt.clusterSelectors()) > 0 || len(c.clusterIDs) > 0 {
+		return c.targetClusters(stmt)
+	}
+
+	clusters, err := c.projectClusters(true)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, cluster := range clusters {
+		_, err := getDbCredId(c.CloudAPIBaseURL, c.clustersPath(cluster.ID), c.Username, c.Password, username)
+		if errors.Is(err, ErrDbCredUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, cluster.ID)
+	}
+	return ids, nil
+}
+
+// managedClusters returns the configured clusters, or every cluster of the
+// project if none is configured.
+func (c *couchbaseCapellaDBConnectionProducer) managedClusters() ([]string, error) {
+	if len(c.clusterIDs) > 0 {
+		return c.clusterIDs, nil
+	}
+	clusters, err := c.projectClusters(true)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, cluster := range clusters {
+		ids = append(ids, cluster.ID)
+	}
+	return ids, nil
+}
+
+// searchClusters returns the clusters to search for resources of a user that
+// may not exist everywhere: the clusters the statement names, or else the
+// configured clusters or every cluster of the project.
+func (c *couchbaseCapellaDBConnectionProducer) searchClusters(stmt *capellaStatement) ([]string, error) {
+	if len(stmt.clusterSelectors()) > 0 {
+		return c.targetClusters(stmt)
+	}
+	return c.managedClusters()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	couchbasecapella "github.com/couchbasecloud/vault-plugin-database-couchbasecapella"
+)
+
+const bulkRevokeUsage = `Usage: couchbasecapella-database-plugin bulk-revoke -config <file> -pattern <glob> [options]
+
+Deletes every database credential whose username matches the pattern. Without
+-execute the matching users are only reported.
+
+`
+
+// runBulkRevoke implements the bulk-revoke subcommand.
+func runBulkRevoke(args []string) error {
+	fs := flag.NewFlagSet("bulk-revoke", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), bulkRevokeUsage)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "", "JSON file with the database configuration, as written to database/config")
+	pattern := fs.String("pattern", "", "glob over usernames, matched case insensitively")
+	isRegexp := fs.Bool("regexp", false, "treat -pattern as a regular expression")
+	execute := fs.Bool("execute", false, "delete the matching users instead of only reporting them")
+	concurrency := fs.Int("concurrency", 4, "maximum number of deletions in flight")
+	reportPath := fs.String("report", "", "write the JSON report to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" || *pattern == "" {
+		fs.Usage()
+		return fmt.Errorf("-config and -pattern are required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	db, err := initializeFromFile(ctx, *configPath, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := db.BulkRevoke(ctx, couchbasecapella.BulkRevokeOptions{
+		Pattern:     *pattern,
+		Regexp:      *isRegexp,
+		Execute:     *execute,
+		Concurrency: *concurrency,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeReport(*reportPath, report); err != nil {
+		return err
+	}
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d users could not be revoked", report.Failed, len(report.Users))
+	}
+	return nil
+}
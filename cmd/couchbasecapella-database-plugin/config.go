@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	couchbasecapella "github.com/couchbasecloud/vault-plugin-database-couchbasecapella"
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+)
+
+// initializeFromFile reads a database configuration, as written to
+// database/config, from path and initializes a plugin instance with it.
+func initializeFromFile(ctx context.Context, path string, verifyConnection bool) (*couchbasecapella.CouchbaseCapellaDB, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	db := couchbasecapella.NewCouchbaseCapellaDB()
+	_, err = db.Initialize(ctx, dbplugin.InitializeRequest{Config: config, VerifyConnection: verifyConnection})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// writeReport writes report as indented JSON to path, or to stdout if path
+// is empty.
+func writeReport(path string, report interface{}) error {
+	var out io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
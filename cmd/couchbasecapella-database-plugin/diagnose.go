@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+const diagnoseUsage = `Usage: couchbasecapella-database-plugin diagnose -config <file> [options]
+
+Inspects the roles and resource scope of the root API key and reports what it
+is missing to manage database credentials and rotate itself. Exits with an
+error if any finding prevents the plugin from working.
+
+`
+
+// runDiagnose implements the diagnose subcommand.
+func runDiagnose(args []string) error {
+	fs := flag.NewFlagSet("diagnose", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), diagnoseUsage)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "", "JSON file with the database configuration, as written to database/config")
+	reportPath := fs.String("report", "", "write the JSON report to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		fs.Usage()
+		return fmt.Errorf("-config is required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// The connection is not verified, so that a strict permission_check
+	// does not fail before the findings are reported.
+	db, err := initializeFromFile(ctx, *configPath, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := db.Diagnose(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := writeReport(*reportPath, report); err != nil {
+		return err
+	}
+
+	if errs := report.Errors(); len(errs) > 0 {
+		return fmt.Errorf("the root API key is missing %d permissions", len(errs))
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	couchbasecapella "github.com/couchbasecloud/vault-plugin-database-couchbasecapella"
@@ -8,7 +9,25 @@ import (
 	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
 )
 
+// commands are the subcommands of the plugin binary. Without a subcommand it
+// serves the plugin to Vault.
+var commands = map[string]func(args []string) error{
+	"bulk-revoke": runBulkRevoke,
+	"diagnose":    runDiagnose,
+	"reap":        runReap,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	err := Run()
 	if err != nil {
 		logger := hclog.New(&hclog.LoggerOptions{})
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	couchbasecapella "github.com/couchbasecloud/vault-plugin-database-couchbasecapella"
+)
+
+const reapUsage = `Usage: couchbasecapella-database-plugin reap -config <file> -max-age <duration> [options]
+
+Deletes the database credentials that username_template generated and that
+are older than -max-age, such as users whose lease Vault lost. Without
+-execute the orphaned users are only reported.
+
+`
+
+// runReap implements the reap subcommand.
+func runReap(args []string) error {
+	fs := flag.NewFlagSet("reap", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), reapUsage)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "", "JSON file with the database configuration, as written to database/config")
+	maxAge := fs.Duration("max-age", 0, "age after which a user is orphaned, above the longest max_ttl of the roles")
+	execute := fs.Bool("execute", false, "delete the orphaned users instead of only reporting them")
+	maxDeletions := fs.Int("max-deletions", 100, "maximum number of users deleted in one run")
+	reportPath := fs.String("report", "", "write the JSON report to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" || *maxAge <= 0 {
+		fs.Usage()
+		return fmt.Errorf("-config and -max-age are required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	db, err := initializeFromFile(ctx, *configPath, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := db.ReapOrphanedUsers(ctx, couchbasecapella.ReapOptions{
+		MaxAge:       *maxAge,
+		DryRun:       !*execute,
+		MaxDeletions: *maxDeletions,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeReport(*reportPath, report); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, orphan := range report.Orphans {
+		if orphan.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d orphaned users could not be deleted", failed, len(report.Orphans))
+	}
+	return nil
+}
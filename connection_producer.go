@@ -1,44 +1,99 @@
 package couchbasecapella
 
 import (
+	"bytes"
 	"context"
 	"crypto/x509"
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/couchbase/gocb/v2"
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/database/helper/connutil"
 	"github.com/mitchellh/mapstructure"
 )
 
+const (
+	clusterTypeProvisioned = "provisioned"
+	clusterTypeInVPC       = "invpc"
+	clusterTypeColumnar    = "columnar"
+)
+
 type couchbaseCapellaDBConnectionProducer struct {
-	Username             string `json:"username"`
-	Password             string `json:"password"`
-	OrganizationID       string `json:"organization_id"`
-	ProjectID            string `json:"project_id"`
-	ClusterID            string `json:"cluster_id"`
-	ClusterType          string `json:"cluster_type"`
-	CloudAPIBaseURL      string `json:"cloud_api_base_url"`
-	ConnectURL           string `json:"connect_url"`
-	CloudAPIClustersPath string `json:"cloud_api_clusters_path"`
-	BucketName           string `json:"bucket_name"`
-	AccessRole           string `json:"access_role"`
+	Username            string   `json:"username"`
+	Password            string   `json:"password"`
+	OrganizationID      string   `json:"organization_id"`
+	ProjectID           string   `json:"project_id"`
+	ClusterID           string   `json:"cluster_id"`
+	ClusterIDs          []string `json:"cluster_ids"`
+	ClusterType         string   `json:"cluster_type"`
+	Deployment          string   `json:"deployment"`
+	CloudAPIBaseURL     string   `json:"cloud_api_base_url"`
+	ConnectURL          string   `json:"connect_url"`
+	BucketName          string   `json:"bucket_name"`
+	AccessRole          string   `json:"access_role"`
+	PermissionCheck     string   `json:"permission_check"`
+	ExpectRootRotation  bool     `json:"expect_root_rotation"`
+	RootRotationMode    string   `json:"root_rotation_mode"`
+	WaitForHealthy      bool     `json:"wait_for_healthy"`
+	WakeCluster         bool     `json:"wake_cluster"`
+	WakeTimeout         string   `json:"wake_timeout"`
+	WaitForPropagation  bool     `json:"wait_for_propagation"`
+	AllowedCIDRNetworks []string `json:"allowed_cidr_networks"`
+
+	KeyExpiryWarningThresholds string `json:"key_expiry_warning_thresholds"`
+	KeyExpiryCheckInterval     string `json:"key_expiry_check_interval"`
 
 	logger      hclog.Logger
 	Hosts       string `json:"hosts"`
 	TLS         bool   `json:"tls"`
 	InsecureTLS bool   `json:"insecure_tls"`
 	Base64Pem   string `json:"base64pem"`
+	CAPinSHA256 string `json:"ca_pin_sha256"`
 
 	Initialized bool
 	rawConfig   map[string]interface{}
 	Type        string
 	cluster     *gocb.Cluster
+	// clusterCAPEM is the CA certificate cluster was connected with.
+	clusterCAPEM []byte
 	sync.RWMutex
+
+	// stateLock guards clusterStates, clusterList and cachedProjectName,
+	// which are read and refreshed while only the read lock is held.
+	stateLock            sync.Mutex
+	clusterStates        map[string]cachedCluster
+	clusterList          []CapellaCluster
+	clusterListFetchedAt time.Time
+	cachedProjectName    string
+	wakeTimeout          time.Duration
+
+	keyExpiresAt           time.Time
+	keyExpiryWarned        int
+	keyExpiryThresholds    []time.Duration
+	keyExpiryCheckInterval time.Duration
+	keyExpiryStop          chan struct{}
+
+	// caPEM caches the CA certificate fetched from Capella when base64pem
+	// is not set.
+	caPEM       []byte
+	caFetchedAt time.Time
+
+	// clusterIDs are the clusters credentials are managed on, cluster_id
+	// first followed by cluster_ids.
+	clusterIDs []string
+
+	// leaseCIDRNetworks are the networks lease CIDRs must lie in, parsed
+	// from allowed_cidr_networks.
+	leaseCIDRNetworks []*net.IPNet
 }
 
 func (c *couchbaseCapellaDBConnectionProducer) secretValues() map[string]string {
@@ -73,13 +128,23 @@ func (c *couchbaseCapellaDBConnectionProducer) Init(ctx context.Context, initCon
 		return nil, err
 	}
 
+	switch c.Deployment {
+	case "":
+		c.Deployment = deploymentCapella
+	case deploymentCapella, deploymentSelfManaged:
+	default:
+		return nil, fmt.Errorf("deployment must be %q or %q", deploymentCapella, deploymentSelfManaged)
+	}
+
 	switch {
-	case len(c.OrganizationID) == 0:
+	case c.Deployment == deploymentCapella && len(c.OrganizationID) == 0:
 		return nil, fmt.Errorf("organization_id cannot be empty")
-	case len(c.ProjectID) == 0:
+	case c.Deployment == deploymentCapella && len(c.ProjectID) == 0:
 		return nil, fmt.Errorf("project_id cannot be empty")
-	case len(c.ClusterID) == 0:
-		return nil, fmt.Errorf("cluster_id cannot be empty")
+	case c.Deployment == deploymentSelfManaged && len(c.Hosts) == 0:
+		return nil, fmt.Errorf("hosts cannot be empty for a %s deployment", deploymentSelfManaged)
+	case c.Deployment == deploymentSelfManaged && len(c.Base64Pem) == 0 && !c.InsecureTLS:
+		return nil, fmt.Errorf("base64pem cannot be empty for a %s deployment unless insecure_tls is set", deploymentSelfManaged)
 	case len(c.Username) == 0:
 		return nil, fmt.Errorf("root username (access_key) cannot be empty")
 	case len(c.Password) == 0:
@@ -89,23 +154,84 @@ func (c *couchbaseCapellaDBConnectionProducer) Init(ctx context.Context, initCon
 	if len(c.CloudAPIBaseURL) == 0 {
 		c.CloudAPIBaseURL = "https://cloudapi.cloud.couchbase.com"
 	}
-	if len(c.ClusterType) == 0 {
-		c.ClusterType = "provisioned"
+	switch c.ClusterType {
+	case "":
+		c.ClusterType = clusterTypeProvisioned
+	case clusterTypeProvisioned, clusterTypeInVPC, clusterTypeColumnar:
+	default:
+		return nil, fmt.Errorf("cluster_type must be one of %q, %q or %q", clusterTypeProvisioned, clusterTypeInVPC, clusterTypeColumnar)
 	}
-	if len(c.CloudAPIClustersPath) == 0 && c.ClusterType == "provisioned" {
-		c.CloudAPIClustersPath = "/v3/clusters"
-	} else if len(c.CloudAPIClustersPath) == 0 && c.ClusterType == "invpc" {
-		c.CloudAPIClustersPath = "/v2/clusters"
-	}
-	c.CloudAPIClustersPath = fmt.Sprintf("/organizations/%s/projects/%s/clusters/%s", c.OrganizationID, c.ProjectID, c.ClusterID)
+	// Without cluster_id and cluster_ids every role names its clusters in
+	// its statements.
+	c.clusterIDs = parseClusterIDs(c.ClusterID, c.ClusterIDs)
+
+	// The project may have changed, read its name again when needed.
+	c.stateLock.Lock()
+	c.cachedProjectName = ""
+	c.stateLock.Unlock()
 
 	if len(c.AccessRole) == 0 {
 		c.AccessRole = "data_writer"
 	}
 
+	switch c.PermissionCheck {
+	case "":
+		c.PermissionCheck = permissionCheckWarn
+	case permissionCheckWarn, permissionCheckStrict, permissionCheckOff:
+	default:
+		return nil, fmt.Errorf("permission_check must be one of %q, %q or %q", permissionCheckWarn, permissionCheckStrict, permissionCheckOff)
+	}
+
+	switch c.RootRotationMode {
+	case "":
+		c.RootRotationMode = rootRotationRotate
+	case rootRotationRotate:
+	case rootRotationReplace:
+		// Vault only saves the new secret of a rotated root credential, so
+		// the access key ID of a replacement key would be lost.
+		return nil, fmt.Errorf("root_rotation_mode %q is not supported, as Vault cannot save the access key ID of a new root API key; use %q",
+			rootRotationReplace, rootRotationRotate)
+	default:
+		return nil, fmt.Errorf("root_rotation_mode must be %q", rootRotationRotate)
+	}
+
+	c.leaseCIDRNetworks, err = parseCIDRNetworks(c.AllowedCIDRNetworks)
+	if err != nil {
+		return nil, err
+	}
+
+	c.wakeTimeout = defaultWakeTimeout
+	if len(c.WakeTimeout) > 0 {
+		c.wakeTimeout, err = parseutil.ParseDurationSecond(c.WakeTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid wake_timeout: %w", err)
+		}
+	}
+
+	c.keyExpiryThresholds, err = parseKeyExpiryThresholds(c.KeyExpiryWarningThresholds)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key_expiry_warning_thresholds: %w", err)
+	}
+	c.keyExpiryCheckInterval = defaultKeyExpiryCheckInterval
+	if len(c.KeyExpiryCheckInterval) > 0 {
+		c.keyExpiryCheckInterval, err = parseutil.ParseDurationSecond(c.KeyExpiryCheckInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key_expiry_check_interval: %w", err)
+		}
+		if c.keyExpiryCheckInterval <= 0 {
+			return nil, fmt.Errorf("key_expiry_check_interval must be positive")
+		}
+	}
+
 	if c.TLS {
-		if len(c.Base64Pem) == 0 {
-			return nil, fmt.Errorf("base64pem cannot be empty")
+		// Without base64pem the CA certificate is fetched from Capella.
+		if len(c.Base64Pem) == 0 && (c.Deployment == deploymentSelfManaged || len(c.clusterIDs) != 1) {
+			return nil, fmt.Errorf("base64pem cannot be empty unless exactly one cluster is configured to fetch the certificate from")
+		}
+		if len(c.CAPinSHA256) > 0 {
+			if _, err := parseCAPin(c.CAPinSHA256); err != nil {
+				return nil, err
+			}
 		}
 
 		if !strings.HasPrefix(c.Hosts, "couchbases://") {
@@ -113,14 +239,54 @@ func (c *couchbaseCapellaDBConnectionProducer) Init(ctx context.Context, initCon
 		}
 	}
 
+	if c.WaitForPropagation && len(c.connectionString()) == 0 {
+		return nil, fmt.Errorf("wait_for_propagation requires hosts or connect_url")
+	}
+
 	c.Initialized = true
-	verifyConnection = false // TBD: Check the cluster status with public APIs and don't make the connection
+
+	// Forget the expiry of the previous root API key; it is only read again
+	// when the connection is verified, as an unverified Init does no I/O.
+	if c.keyExpiryStop != nil {
+		close(c.keyExpiryStop)
+		c.keyExpiryStop = nil
+	}
+	c.stateLock.Lock()
+	c.keyExpiresAt = time.Time{}
+	c.keyExpiryWarned = 0
+	c.stateLock.Unlock()
+
+	if c.Deployment == deploymentSelfManaged {
+		if verifyConnection {
+			if err := c.verifySelfManaged(); err != nil {
+				return nil, errwrap.Wrapf("error verifying connection: {{err}}", err)
+			}
+		}
+		return initConfig, nil
+	}
 
 	if verifyConnection {
-		if _, err := c.Connection(ctx); err != nil {
-			c.close()
-			return nil, errwrap.Wrapf("error verifying connection: {{err}}", err)
+		c.refreshKeyExpiry(c.Username, c.Password)
+		if err := c.checkKeyExpiry(); err != nil {
+			return nil, err
+		}
+
+		for _, clusterID := range c.clusterIDs {
+			if err := c.verifyCluster(ctx, clusterID); err != nil {
+				return nil, errwrap.Wrapf("error verifying connection: {{err}}", err)
+			}
 		}
+		if len(c.clusterIDs) == 0 {
+			if _, err := c.projectClusters(true); err != nil {
+				return nil, errwrap.Wrapf("error verifying connection: {{err}}", err)
+			}
+		}
+		if err := c.enforcePermissions(ctx); err != nil {
+			return nil, err
+		}
+
+		c.keyExpiryStop = make(chan struct{})
+		go c.watchKeyExpiry(c.keyExpiryStop)
 	}
 
 	if c.secretValues()["Password"] != "" {
@@ -131,6 +297,72 @@ func (c *couchbaseCapellaDBConnectionProducer) Init(ctx context.Context, initCon
 	return initConfig, nil
 }
 
+// clustersCollectionPath returns the Cloud API path of the clusters, or of the
+// Columnar instances, of the configured organization and project.
+func (c *couchbaseCapellaDBConnectionProducer) clustersCollectionPath() string {
+	collection := "clusters"
+	if c.ClusterType == clusterTypeColumnar {
+		collection = "analyticsClusters"
+	}
+	return fmt.Sprintf("/organizations/%s/projects/%s/%s", c.OrganizationID, c.ProjectID, collection)
+}
+
+// clustersPath returns the Cloud API path of the cluster, or Columnar
+// instance, clusterID in the configured organization and project.
+func (c *couchbaseCapellaDBConnectionProducer) clustersPath(clusterID string) string {
+	return c.clustersCollectionPath() + "/" + clusterID
+}
+
+// parseClusterIDs merges cluster_id and cluster_ids into a list without
+// duplicates. Entries of cluster_ids may themselves be comma separated.
+func parseClusterIDs(clusterID string, clusterIDs []string) []string {
+	var ids []string
+	for _, raw := range append([]string{clusterID}, clusterIDs...) {
+		for _, id := range strings.Split(raw, ",") {
+			id = strings.TrimSpace(id)
+			if id != "" && !strutil.StrListContains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// verifyCluster checks through the Capella management API that the cluster
+// clusterID exists and is reachable with the configured API key. A cluster
+// that is not healthy only logs a warning: it may be off on a schedule or
+// busy, and credential requests check its state again.
+func (c *couchbaseCapellaDBConnectionProducer) verifyCluster(ctx context.Context, clusterID string) error {
+	cluster, err := GetCapellaCluster(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password)
+	if err != nil {
+		var apiErr *CapellaAPIError
+		if !errors.As(err, &apiErr) {
+			return fmt.Errorf("unable to reach the Capella management API at %s, check cloud_api_base_url: %w", c.CloudAPIBaseURL, err)
+		}
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized:
+			return fmt.Errorf("the API key %s was rejected by Capella, check that username (access key) and password (secret key) are correct and the key has not expired", c.Username)
+		case http.StatusForbidden:
+			return fmt.Errorf("the API key %s is not allowed to access cluster %s, grant the key a role on project %s", c.Username, clusterID, c.ProjectID)
+		case http.StatusNotFound:
+			return fmt.Errorf("cluster %s was not found in organization %s and project %s, check organization_id, project_id and cluster_id", clusterID, c.OrganizationID, c.ProjectID)
+		}
+		return err
+	}
+
+	switch {
+	case cluster.CurrentState == clusterStateHealthy:
+	case cluster.CurrentState == clusterStateTurnedOff && c.WakeCluster:
+		c.logger.Info("the cluster is turned off, it is turned on when a credential is requested", "cluster", clusterID)
+	case transitionalClusterStates[cluster.CurrentState] && c.WaitForHealthy:
+		c.logger.Info("the cluster is not healthy yet, credential requests wait for it", "cluster", clusterID, "state", cluster.CurrentState)
+	default:
+		err := &ClusterStateError{ClusterID: clusterID, Name: cluster.Name, State: cluster.CurrentState}
+		c.logger.Warn("credentials cannot be managed on the cluster until it is healthy", "cluster", clusterID, "error", err)
+	}
+	return nil
+}
+
 func (c *couchbaseCapellaDBConnectionProducer) Initialize(ctx context.Context, config map[string]interface{}, verifyConnection bool) error {
 	_, err := c.Init(ctx, config, verifyConnection)
 	return err
@@ -144,17 +376,67 @@ func (c *couchbaseCapellaDBConnectionProducer) Connection(ctx context.Context) (
 		return nil, connutil.ErrNotInitialized
 	}
 
+	var ca []byte
+	if c.TLS {
+		var err error
+		ca, err = c.clusterCA()
+		if err != nil {
+			if c.cluster == nil {
+				return nil, err
+			}
+			// Keep the connection until the certificate can be checked.
+			c.logger.Warn("unable to check the cluster CA certificate", "error", err)
+			return c.cluster, nil
+		}
+	}
+
 	if c.cluster != nil {
-		return c.cluster, nil
+		if bytes.Equal(ca, c.clusterCAPEM) {
+			return c.cluster, nil
+		}
+		c.logger.Info("the cluster CA certificate changed, reconnecting")
+		if err := c.cluster.Close(&gocb.ClusterCloseOptions{}); err != nil {
+			c.logger.Warn("unable to close the connection", "error", err)
+		}
+		c.cluster = nil
+	}
+
+	cluster, err := c.connect(ctx, c.Username, c.Password, computeTimeout(ctx))
+	if err != nil {
+		return nil, err
+	}
+	c.cluster = cluster
+	c.clusterCAPEM = ca
+	return c.cluster, nil
+}
+
+// connectionString returns the data-plane connection string, hosts or else
+// connect_url.
+func (c *couchbaseCapellaDBConnectionProducer) connectionString() string {
+	if len(c.Hosts) > 0 {
+		return c.Hosts
 	}
+	return c.ConnectURL
+}
+
+// connect opens a data-plane connection as username and waits, for at most
+// timeout, until it is ready. The caller closes the returned cluster.
+func (c *couchbaseCapellaDBConnectionProducer) connect(ctx context.Context, username, password string, timeout time.Duration) (*gocb.Cluster, error) {
+	return c.connectTo(ctx, c.connectionString(), c.BucketName, username, password, timeout)
+}
+
+// connectTo opens a connection to connStr as username. It waits until
+// bucketName is ready, or the cluster if bucketName is empty. The caller
+// closes the returned cluster.
+func (c *couchbaseCapellaDBConnectionProducer) connectTo(ctx context.Context, connStr, bucketName, username, password string, timeout time.Duration) (*gocb.Cluster, error) {
 	var err error
 	var sec gocb.SecurityConfig
 	var pem []byte
 
 	if c.TLS {
-		pem, err = base64.StdEncoding.DecodeString(c.Base64Pem)
+		pem, err = c.clusterCA()
 		if err != nil {
-			return nil, errwrap.Wrapf("error decoding Base64Pem: {{err}}", err)
+			return nil, err
 		}
 		rootCAs := x509.NewCertPool()
 		ok := rootCAs.AppendCertsFromPEM([]byte(pem))
@@ -167,11 +449,11 @@ func (c *couchbaseCapellaDBConnectionProducer) Connection(ctx context.Context) (
 		}
 	}
 
-	c.cluster, err = gocb.Connect(
-		c.Hosts,
+	cluster, err := gocb.Connect(
+		connStr,
 		gocb.ClusterOptions{
-			Username:       c.Username,
-			Password:       c.Password,
+			Username:       username,
+			Password:       password,
 			SecurityConfig: sec,
 		})
 	if err != nil {
@@ -181,26 +463,33 @@ func (c *couchbaseCapellaDBConnectionProducer) Connection(ctx context.Context) (
 	// For databases 6.0 and earlier, we will need to open a `Bucket instance before connecting to any other
 	// HTTP services such as UserManager.
 
-	if c.BucketName != "" {
-		bucket := c.cluster.Bucket(c.BucketName)
+	if bucketName != "" {
+		bucket := cluster.Bucket(bucketName)
 		// We wait until the bucket is definitely connected and setup.
-		err = bucket.WaitUntilReady(computeTimeout(ctx), nil)
+		err = bucket.WaitUntilReady(timeout, nil)
 		if err != nil {
+			cluster.Close(nil)
 			return nil, errwrap.Wrapf("error in Connection waiting for bucket: {{err}}", err)
 		}
 	} else {
-		err = c.cluster.WaitUntilReady(computeTimeout(ctx), nil)
+		err = cluster.WaitUntilReady(timeout, nil)
 
 		if err != nil {
+			cluster.Close(nil)
 			return nil, errwrap.Wrapf("error in Connection waiting for cluster: {{err}}", err)
 		}
 	}
 
-	return c.cluster, nil
+	return cluster, nil
 }
 
 // close terminates the database connection without locking
 func (c *couchbaseCapellaDBConnectionProducer) close() error {
+	if c.keyExpiryStop != nil {
+		close(c.keyExpiryStop)
+		c.keyExpiryStop = nil
+	}
+
 	if c.cluster != nil {
 		if err := c.cluster.Close(&gocb.ClusterCloseOptions{}); err != nil {
 			return err
@@ -208,6 +497,7 @@ func (c *couchbaseCapellaDBConnectionProducer) close() error {
 	}
 
 	c.cluster = nil
+	c.clusterCAPEM = nil
 	return nil
 }
 
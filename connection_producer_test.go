@@ -0,0 +1,145 @@
+package couchbasecapella
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func newTestConnectionProducer(baseURL string) *couchbaseCapellaDBConnectionProducer {
+	return &couchbaseCapellaDBConnectionProducer{
+		Username:        "ACCESS",
+		Password:        "SECRET",
+		OrganizationID:  "org",
+		ProjectID:       "proj",
+		ClusterID:       "cluster",
+		clusterIDs:      []string{"cluster"},
+		CloudAPIBaseURL: baseURL,
+	}
+}
+
+func TestConnectionProducer_VerifyCluster(t *testing.T) {
+	tests := map[string]struct {
+		status  int
+		body    string
+		wantErr string
+	}{
+		"healthy":      {http.StatusOK, `{"id":"cluster","name":"c1","currentState":"healthy"}`, ""},
+		"turned off":   {http.StatusOK, `{"id":"cluster","name":"c1","currentState":"turnedOff"}`, ""},
+		"bad key":      {http.StatusUnauthorized, `{}`, "was rejected by Capella"},
+		"no access":    {http.StatusForbidden, `{}`, "is not allowed to access cluster"},
+		"bad cluster":  {http.StatusNotFound, `{}`, "was not found in organization"},
+		"server error": {http.StatusInternalServerError, `{}`, "returned 500"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/organizations/org/projects/proj/clusters/cluster" {
+					t.Errorf("unexpected path %s", r.URL.Path)
+				}
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer srv.Close()
+
+			cp := newTestConnectionProducer(srv.URL)
+			cp.logger = hclog.NewNullLogger()
+			err := cp.verifyCluster(context.Background(), "cluster")
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case test.wantErr != "" && err == nil:
+				t.Fatalf("expected error containing %q", test.wantErr)
+			case test.wantErr != "" && !strings.Contains(err.Error(), test.wantErr):
+				t.Fatalf("expected error containing %q, got %q", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseClusterIDs(t *testing.T) {
+	got := parseClusterIDs("a", []string{"b, c", "a", " ", "d"})
+	want := []string{"a", "b", "c", "d"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestConnectionProducer_InitWithoutVerification(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cp := &couchbaseCapellaDBConnectionProducer{}
+	_, err := cp.Init(context.Background(), map[string]interface{}{
+		"username":           "ACCESS",
+		"password":           "SECRET",
+		"organization_id":    "org",
+		"project_id":         "proj",
+		"cluster_id":         "cluster",
+		"cloud_api_base_url": srv.URL,
+	}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer cp.Close()
+
+	if calls != 0 {
+		t.Fatalf("expected no requests without verify_connection, got %d", calls)
+	}
+	if cp.keyExpiryStop != nil {
+		t.Fatalf("expected the key expiry not to be watched without verify_connection")
+	}
+}
+
+func TestConnectionProducer_InitTurnedOffCluster(t *testing.T) {
+	tests := map[string]map[string]interface{}{
+		"wake cluster":     {"wake_cluster": true},
+		"warning only":     {},
+		"several clusters": {"cluster_ids": "other"},
+	}
+
+	for name, extra := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/organizations/org/apikeys/ACCESS":
+					w.Write([]byte(`{"id":"ACCESS","organizationRoles":["organizationOwner"]}`))
+				case "/organizations/org/projects/proj/clusters/cluster":
+					w.Write([]byte(`{"id":"cluster","name":"c1","currentState":"turnedOff"}`))
+				case "/organizations/org/projects/proj/clusters/other":
+					w.Write([]byte(`{"id":"other","name":"c2","currentState":"healthy"}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer srv.Close()
+
+			config := map[string]interface{}{
+				"username":           "ACCESS",
+				"password":           "SECRET",
+				"organization_id":    "org",
+				"project_id":         "proj",
+				"cluster_id":         "cluster",
+				"cloud_api_base_url": srv.URL,
+			}
+			for k, v := range extra {
+				config[k] = v
+			}
+			cp := &couchbaseCapellaDBConnectionProducer{}
+			_, err := cp.Init(context.Background(), config, true)
+			if err != nil {
+				t.Fatalf("expected a turned off cluster not to fail the configuration, got %s", err)
+			}
+			cp.Close()
+		})
+	}
+}
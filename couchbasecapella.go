@@ -2,6 +2,7 @@ package couchbasecapella
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/go-secure-stdlib/strutil"
 	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/hashicorp/vault/sdk/database/helper/connutil"
 	"github.com/hashicorp/vault/sdk/database/helper/credsutil"
 	"github.com/hashicorp/vault/sdk/helper/template"
 )
@@ -26,6 +28,16 @@ const (
 			]
 		  }
 		  }]}`
+	defaultColumnarUserRole = `{"access": [{
+		  "privileges": [
+			"collection_select"
+		  ],
+		  "resources": {
+			"databases": [
+				{ "name" :"*" }
+			]
+		  }
+		  }]}`
 	defaultTimeout = 20000 * time.Millisecond
 
 	defaultUserNameTemplate = `{{printf "V_%s_%s_%s_%s" (printf "%s" .DisplayName | uppercase | truncate 64) (printf "%s" .RoleName | uppercase | truncate 64) (random 20 | uppercase) (unix_time) | truncate 128}}`
@@ -39,8 +51,12 @@ var logger hclog.Logger
 type CouchbaseCapellaDB struct {
 	*couchbaseCapellaDBConnectionProducer
 	credsutil.CredentialsProducer
-	usernameProducer template.StringTemplate
-	logger           hclog.Logger
+	usernameTemplate string
+	// usernameFunctions are the functions username_template calls.
+	usernameFunctions map[string]bool
+	usernameProducer  template.StringTemplate
+	usernameRules     usernameRules
+	logger            hclog.Logger
 }
 
 // New implements builtinplugins.BuiltinFactory
@@ -51,6 +67,12 @@ func New() (interface{}, error) {
 	return dbType, nil
 }
 
+// NewCouchbaseCapellaDB returns an uninitialized plugin instance for use
+// outside of Vault, such as by the command line tools of the plugin binary.
+func NewCouchbaseCapellaDB() *CouchbaseCapellaDB {
+	return new()
+}
+
 func new() *CouchbaseCapellaDB {
 	connProducer := &couchbaseCapellaDBConnectionProducer{}
 	connProducer.Type = couchbaseCapellaTypeName
@@ -73,16 +95,33 @@ func (c *CouchbaseCapellaDB) Initialize(ctx context.Context, req dbplugin.Initia
 		usernameTemplate = defaultUserNameTemplate
 	}
 
-	up, err := template.NewTemplate(template.Template(usernameTemplate))
+	c.usernameRules, err = parseUsernameRules(req.Config)
+	if err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
+
+	up, err := c.newUsernameProducer(usernameTemplate, false)
 	if err != nil {
 		return dbplugin.InitializeResponse{}, fmt.Errorf("unable to initialize username template: %w", err)
 	}
+	c.usernameTemplate = usernameTemplate
 	c.usernameProducer = up
+	c.usernameFunctions, err = templateFunctions(usernameTemplate)
+	if err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
 
 	err = c.couchbaseCapellaDBConnectionProducer.Initialize(ctx, req.Config, req.VerifyConnection)
 	if err != nil {
 		return dbplugin.InitializeResponse{}, err
 	}
+
+	// Checked once the connection configuration is known, as cluster_name
+	// and project_name depend on it.
+	err = c.validateUsernameTemplate(usernameTemplate)
+	if err != nil {
+		return dbplugin.InitializeResponse{}, err
+	}
 	resp := dbplugin.InitializeResponse{
 		Config: req.Config,
 	}
@@ -95,13 +134,34 @@ func (c *CouchbaseCapellaDB) NewUser(ctx context.Context, req dbplugin.NewUserRe
 	c.RLock()
 	defer c.RUnlock()
 
-	username, err := c.usernameProducer.Generate(req.UsernameConfig)
+	err := c.checkKeyExpiry()
 	if err != nil {
-		return dbplugin.NewUserResponse{}, fmt.Errorf("failed to generate username: %w", err)
+		return dbplugin.NewUserResponse{}, err
+	}
+
+	stmt, err := firstStatement(req.Statements.Commands)
+	if err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
+	err = stmt.checkDeployment(c.Deployment)
+	if err != nil {
+		return dbplugin.NewUserResponse{}, err
 	}
-	username = strings.ToUpper(username)
 
-	err = newUser(ctx, c.couchbaseCapellaDBConnectionProducer, username, req)
+	username, err := c.generateUsername(req.UsernameConfig, stmt)
+	if err != nil {
+		return dbplugin.NewUserResponse{}, err
+	}
+	switch {
+	case c.Deployment == deploymentSelfManaged:
+		err = newSelfManagedUser(ctx, c.couchbaseCapellaDBConnectionProducer, username, stmt, req)
+	case stmt.CredentialType == credentialTypeAPIKey:
+		username, err = newAPIKeyCredential(ctx, c.couchbaseCapellaDBConnectionProducer, username, stmt, req)
+	case stmt.CredentialType == credentialTypeAppEndpointUser:
+		err = newAppEndpointUser(ctx, c.couchbaseCapellaDBConnectionProducer, username, stmt, req)
+	default:
+		err = newUser(ctx, c.couchbaseCapellaDBConnectionProducer, username, req)
+	}
 	if err != nil {
 		return dbplugin.NewUserResponse{}, err
 	}
@@ -113,58 +173,401 @@ func (c *CouchbaseCapellaDB) NewUser(ctx context.Context, req dbplugin.NewUserRe
 	return resp, nil
 }
 
+// generateUsername renders the username template for metadata and turns
+// the result into a valid Capella username. If the statement names the
+// clusters of the user, cluster_name names the cluster it targets.
+func (c *CouchbaseCapellaDB) generateUsername(metadata dbplugin.UsernameMetadata, stmt *capellaStatement) (string, error) {
+	producer := c.usernameProducer
+	if c.usernameFunctions["cluster_name"] && len(stmt.clusterSelectors()) > 0 && c.Deployment != deploymentSelfManaged {
+		clusterIDs, err := c.targetClusters(stmt)
+		if err != nil {
+			return "", err
+		}
+		opts := append([]template.Opt{template.Template(c.usernameTemplate)}, c.usernameTemplateFunctions(false)...)
+		opts = append(opts, template.Function("cluster_name", func() (string, error) {
+			return c.clusterName(clusterIDs)
+		}))
+		producer, err = template.NewTemplate(opts...)
+		if err != nil {
+			return "", fmt.Errorf("unable to initialize username template: %w", err)
+		}
+	}
+	return c.renderUsername(producer, metadata)
+}
+
+// renderUsername renders a username with producer and applies the username
+// rules.
+func (c *CouchbaseCapellaDB) renderUsername(producer template.StringTemplate, metadata dbplugin.UsernameMetadata) (string, error) {
+	username, err := producer.Generate(metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate username: %w", err)
+	}
+	username = c.usernameRules.apply(username)
+	err = validateUsername(username)
+	if err != nil {
+		return "", fmt.Errorf("invalid username generated from username_template: %w", err)
+	}
+	return username, nil
+}
+
 func (c *CouchbaseCapellaDB) UpdateUser(ctx context.Context, req dbplugin.UpdateUserRequest) (dbplugin.UpdateUserResponse, error) {
 	if req.Password != nil {
 		newpassword := req.Password.NewPassword
-		_, err := c.changeUserPassword(ctx, req.Username, newpassword)
-		return dbplugin.UpdateUserResponse{}, err
+		if req.Username == c.rootUsername() {
+			err := c.rotateRootCredentials(ctx, newpassword)
+			return dbplugin.UpdateUserResponse{}, err
+		}
+		_, err := c.changeUserPassword(ctx, req.Username, newpassword, req.Password.Statements.Commands)
+		if err != nil {
+			return dbplugin.UpdateUserResponse{}, err
+		}
+	}
+	if req.Expiration != nil {
+		err := c.changeUserExpiration(req.Username, req.Expiration.NewExpiration, req.Expiration.Statements.Commands)
+		if err != nil {
+			return dbplugin.UpdateUserResponse{}, err
+		}
 	}
 	return dbplugin.UpdateUserResponse{}, nil
 }
 
+// changeUserExpiration extends the lease CIDRs of a renewed database user
+// whose renewal statement sets allowed_cidr. Capella credentials themselves do
+// not expire.
+func (c *CouchbaseCapellaDB) changeUserExpiration(username string, expiration time.Time, statements []string) error {
+	// Don't let anyone write the config while we're using it
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.Deployment == deploymentSelfManaged || expiration.IsZero() {
+		return nil
+	}
+	stmt, err := firstStatement(statements)
+	if err != nil {
+		return err
+	}
+	// Capella cannot extend the expiry of an API key, which was set from the
+	// lease when the key was issued. Renewing the lease would outlive it.
+	apiKey, err := isAPIKeyCredential(c.couchbaseCapellaDBConnectionProducer, username)
+	if err != nil {
+		return err
+	}
+	if apiKey || stmt.CredentialType == credentialTypeAPIKey {
+		return fmt.Errorf("the lease of API key %s cannot be renewed, as the key expires with the lease it was issued with", username)
+	}
+	if stmt.AllowedCIDR == "" || stmt.CredentialType != credentialTypeDatabase {
+		return nil
+	}
+	clusterIDs, err := c.userClusters(stmt, username)
+	if err != nil {
+		return err
+	}
+	return extendLeaseCIDRs(c.couchbaseCapellaDBConnectionProducer, clusterIDs, username, expiration)
+}
+
 func (c *CouchbaseCapellaDB) DeleteUser(ctx context.Context, req dbplugin.DeleteUserRequest) (dbplugin.DeleteUserResponse, error) {
 	// Don't let anyone write the config while we're using it
 	c.RLock()
 	defer c.RUnlock()
 
-	err := DeleteCapellaDbCredUser(c.CloudAPIBaseURL, c.couchbaseCapellaDBConnectionProducer.CloudAPIClustersPath,
-		c.couchbaseCapellaDBConnectionProducer.Username,
-		c.couchbaseCapellaDBConnectionProducer.Password,
-		req.Username)
+	err := c.checkKeyExpiry()
 	if err != nil {
 		return dbplugin.DeleteUserResponse{}, err
 	}
 
+	stmt, err := firstStatement(req.Statements.Commands)
+	if err != nil {
+		return dbplugin.DeleteUserResponse{}, err
+	}
+	if c.Deployment == deploymentSelfManaged {
+		return dbplugin.DeleteUserResponse{}, deleteSelfManagedUser(c.couchbaseCapellaDBConnectionProducer, req.Username)
+	}
+	apiKey, err := isAPIKeyCredential(c.couchbaseCapellaDBConnectionProducer, req.Username)
+	if err != nil {
+		return dbplugin.DeleteUserResponse{}, err
+	}
+	switch {
+	case apiKey:
+		return dbplugin.DeleteUserResponse{}, deleteAPIKeyCredential(c.couchbaseCapellaDBConnectionProducer, req.Username)
+	case stmt.CredentialType == credentialTypeAPIKey:
+		c.logger.Info("API key to revoke was already deleted", "key", req.Username)
+		return dbplugin.DeleteUserResponse{}, nil
+	case stmt.CredentialType == credentialTypeAppEndpointUser:
+		return dbplugin.DeleteUserResponse{}, deleteAppEndpointUser(c.couchbaseCapellaDBConnectionProducer, stmt, req.Username)
+	}
+
+	clusterIDs, err := c.userClusters(stmt, req.Username)
+	if err != nil {
+		return dbplugin.DeleteUserResponse{}, err
+	}
+
+	// A failed deletion cannot be undone, so try every cluster and report
+	// all failures; Vault retries the revocation. A user that is already
+	// gone, such as after a partial failure, counts as deleted.
+	var errs []error
+	found := false
+	for _, clusterID := range clusterIDs {
+		err := DeleteCapellaDbCredUser(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password, req.Username)
+		if errors.Is(err, ErrDbCredUserNotFound) {
+			c.logger.Info("user to revoke was already deleted", "user", req.Username, "cluster", clusterID)
+			continue
+		}
+		found = true
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", clusterID, err))
+		}
+	}
+	// The revocation statements may not say the user is an App Endpoint
+	// user. A user found nowhere was already deleted, for example by
+	// bulk-revoke, reap or by hand, and counts as revoked.
+	if !found {
+		locations, err := findAppEndpointUser(c.couchbaseCapellaDBConnectionProducer, stmt, req.Username)
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+		case len(locations) == 0:
+			c.logger.Warn("user to revoke was not found on any cluster or app endpoint, treating it as already deleted",
+				"user", req.Username, "project", c.ProjectID)
+		}
+		for _, location := range locations {
+			if err := deleteAppEndpointUser(c.couchbaseCapellaDBConnectionProducer, location.statement(), req.Username); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	// Lease CIDRs are found by their comment, whatever the revocation
+	// statements say, and on every cluster, as the user may already be gone
+	// from some after a partial failure.
+	cidrClusterIDs, err := c.searchClusters(stmt)
+	if err == nil {
+		err = removeLeaseCIDRs(c.couchbaseCapellaDBConnectionProducer, cidrClusterIDs, req.Username)
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return dbplugin.DeleteUserResponse{}, errors.Join(errs...)
+	}
+
 	return dbplugin.DeleteUserResponse{}, nil
 }
 
+// Diagnose inspects the roles and resource scope of the configured root API key
+// and reports what it is missing to manage database credentials and rotate itself.
+func (c *CouchbaseCapellaDB) Diagnose(ctx context.Context) (*PermissionReport, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if !c.Initialized {
+		return nil, connutil.ErrNotInitialized
+	}
+	return c.checkPermissions(ctx)
+}
+
 func newUser(ctx context.Context, c *couchbaseCapellaDBConnectionProducer, username string, req dbplugin.NewUserRequest) error {
 	statements := removeEmpty(req.Statements.Commands)
-	if len(statements) == 0 {
+	if len(statements) == 0 && c.ClusterType == clusterTypeColumnar {
+		statements = append(statements, defaultColumnarUserRole)
+	} else if len(statements) == 0 {
 		statements = append(statements, defaultCouchbaseCapellaUserRole)
 	}
 
-	err := CreateCapellaDbCredUser(c.CloudAPIBaseURL, c.CloudAPIClustersPath, c.Username, c.Password,
-		username, req.Password, statements[0])
+	stmt, err := parseStatement(statements[0])
+	if err != nil {
+		return err
+	}
+	err = stmt.validateAccess(c.ClusterType)
+	if err != nil {
+		return fmt.Errorf("invalid access statement: %w", err)
+	}
+	if stmt.Canary != nil && len(c.connectionString()) == 0 {
+		return fmt.Errorf("the canary of the role requires hosts or connect_url in the database configuration")
+	}
+	var allowedCIDR string
+	if stmt.AllowedCIDR != "" {
+		allowedCIDR, err = renderAllowedCIDR(stmt.AllowedCIDR, req.UsernameConfig)
+		if err != nil {
+			return err
+		}
+		err = c.checkLeaseCIDR(allowedCIDR)
+		if err != nil {
+			return err
+		}
+	}
+	clusterIDs, err := c.targetClusters(stmt)
 	if err != nil {
 		return err
 	}
 
+	for _, clusterID := range clusterIDs {
+		err := c.ensureClusterHealthy(ctx, c.clustersPath(clusterID))
+		if err != nil {
+			return err
+		}
+	}
+	if c.ClusterType != clusterTypeColumnar && stmt.usesCollections() {
+		for _, clusterID := range clusterIDs {
+			v, err := c.capellaClusterVersion(clusterID)
+			if err != nil {
+				return err
+			}
+			err = checkCollectionsSupported(v, "cluster "+clusterID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var created []string
+	for _, clusterID := range clusterIDs {
+		err := CreateCapellaDbCredUser(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password,
+			username, req.Password, statements[0])
+		if err != nil {
+			rollbackNewUser(c, username, created)
+			return fmt.Errorf("failed to create user %s on cluster %s: %w", username, clusterID, err)
+		}
+		created = append(created, clusterID)
+	}
+
+	if allowedCIDR != "" {
+		err = addLeaseCIDRs(c, clusterIDs, username, allowedCIDR, req.Expiration)
+		if err != nil {
+			rollbackNewUser(c, username, created)
+			return err
+		}
+	}
+
+	err = c.verifyNewUser(ctx, clusterIDs, stmt, username, req.Password)
+	if err != nil {
+		if allowedCIDR != "" {
+			if cidrErr := removeLeaseCIDRs(c, clusterIDs, username); cidrErr != nil {
+				c.logger.Error("failed to roll back allowed cidr", "user", username, "error", cidrErr)
+			}
+		}
+		rollbackNewUser(c, username, created)
+		return err
+	}
+
+	return nil
+}
+
+// rollbackNewUser deletes username from the clusters it was already created on.
+func rollbackNewUser(c *couchbaseCapellaDBConnectionProducer, username string, clusterIDs []string) {
+	for _, clusterID := range clusterIDs {
+		err := DeleteCapellaDbCredUser(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password, username)
+		if err != nil {
+			c.logger.Error("failed to roll back user creation", "user", username, "cluster", clusterID, "error", err)
+		}
+	}
+}
+
+// verifyNewUser waits, if configured, until the new user can connect to the
+// data plane of every cluster in clusterIDs and runs the canary of the
+// statement there. A canary implies waiting, as it would otherwise fail
+// before the credentials have propagated. A self-managed deployment passes
+// no clusters and is checked on its hosts.
+func (c *couchbaseCapellaDBConnectionProducer) verifyNewUser(ctx context.Context, clusterIDs []string, stmt *capellaStatement, username, password string) error {
+	if !c.WaitForPropagation && stmt.Canary == nil {
+		return nil
+	}
+	connStrs, err := c.dataPlanes(clusterIDs)
+	if err != nil {
+		return err
+	}
+	for _, connStr := range connStrs {
+		err := c.waitForPropagation(ctx, connStr, username, password)
+		if err != nil {
+			return err
+		}
+		if stmt.Canary != nil {
+			err = c.runCanary(ctx, connStr, username, password, stmt.Canary)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-func (c *CouchbaseCapellaDB) changeUserPassword(ctx context.Context, username, password string) (string, error) {
+func (c *CouchbaseCapellaDB) changeUserPassword(ctx context.Context, username, password string, statements []string) (string, error) {
 	// Don't let anyone write the config while we're using it
 	c.RLock()
 	defer c.RUnlock()
-	pwd, err := UpdateCapellaDbCredUser(c.CloudAPIBaseURL, c.CloudAPIClustersPath, c.Username, c.Password,
-		username, password)
 
+	err := c.checkKeyExpiry()
 	if err != nil {
-		return pwd, err
+		return "", err
+	}
+
+	stmt, err := firstStatement(statements)
+	if err != nil {
+		return "", err
+	}
+	if c.Deployment == deploymentSelfManaged {
+		return "", updateSelfManagedUserPassword(c.couchbaseCapellaDBConnectionProducer, username, password)
+	}
+	apiKey, err := isAPIKeyCredential(c.couchbaseCapellaDBConnectionProducer, username)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case apiKey:
+		return RotateCapellaAPIKey(c.CloudAPIBaseURL, c.OrganizationID, c.Username, c.Password, username, password)
+	case stmt.CredentialType == credentialTypeAPIKey:
+		return "", fmt.Errorf("API key %s was not found", username)
+	case stmt.CredentialType == credentialTypeAppEndpointUser:
+		return "", updateAppEndpointUserPassword(c.couchbaseCapellaDBConnectionProducer, stmt, username, password)
+	}
+
+	clusterIDs, err := c.userClusters(stmt, username)
+	if err != nil {
+		return "", err
+	}
+
+	// The previous password is unknown so an update cannot be rolled back.
+	// Make sure the user exists everywhere before changing anything; after a
+	// partial failure Vault retries with the same password on every cluster.
+	var missing []string
+	for _, clusterID := range clusterIDs {
+		_, err := getDbCredId(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password, username)
+		if errors.Is(err, ErrDbCredUserNotFound) {
+			missing = append(missing, clusterID)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("user %s cannot be updated on cluster %s: %w", username, clusterID, err)
+		}
+	}
+	if len(missing) > 0 && len(missing) < len(clusterIDs) {
+		return "", fmt.Errorf("user %s cannot be updated, it was not found on clusters %s", username, strings.Join(missing, ", "))
+	}
+	if len(missing) == len(clusterIDs) {
+		// The rotation statements may not say the user is an App Endpoint
+		// user.
+		locations, err := findAppEndpointUser(c.couchbaseCapellaDBConnectionProducer, stmt, username)
+		if err != nil {
+			return "", err
+		}
+		if len(locations) == 0 {
+			return "", fmt.Errorf("user %s was not found on any cluster or app endpoint of project %s", username, c.ProjectID)
+		}
+		for _, location := range locations {
+			if err := updateAppEndpointUserPassword(c.couchbaseCapellaDBConnectionProducer, location.statement(), username, password); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+
+	for _, clusterID := range clusterIDs {
+		_, err := UpdateCapellaDbCredUser(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password,
+			username, password)
+		if err != nil {
+			return "", fmt.Errorf("failed to update user %s on cluster %s: %w", username, clusterID, err)
+		}
 	}
 
-	return pwd, nil
+	return "", nil
 }
 
 func removeEmpty(strs []string) []string {
@@ -3,9 +3,13 @@ package couchbasecapella
 import (
 	"context"
 	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/go-hclog"
 	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
 	dbtesting "github.com/hashicorp/vault/sdk/database/dbplugin/v5/testing"
 	"github.com/labstack/gommon/random"
@@ -342,3 +346,93 @@ func testCreateuser_UsernameTemplate_LongUsername(t *testing.T) {
 	password := "MFNdINEHyXo4cJFcjxQ!bHq%@Gnoi2hyOT5sOXvxjUnWfqrnMeW98H6Uu%MiBn0V"
 	doCouchbaseCapellaDBNewCredentials(t, username, password, rolename)
 }
+
+func TestNewUser_RollsBackOnClusterFailure(t *testing.T) {
+	var deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/users"):
+			w.Write([]byte(`{"data":[{"id":"user-id","name":"V_USER"}],"cursor":{"pages":{"page":1}}}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"id":"c","currentState":"healthy"}`))
+		case r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/clusters/c2/"):
+			w.WriteHeader(http.StatusBadRequest)
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	cp := &couchbaseCapellaDBConnectionProducer{
+		Username:        "ACCESS",
+		Password:        "SECRET",
+		OrganizationID:  "org",
+		ProjectID:       "proj",
+		CloudAPIBaseURL: srv.URL,
+		clusterIDs:      []string{"c1", "c2", "c3"},
+		logger:          hclog.NewNullLogger(),
+	}
+
+	err := newUser(context.Background(), cp, "V_USER", dbplugin.NewUserRequest{Password: "pwd"})
+	if err == nil || !strings.Contains(err.Error(), "cluster c2") {
+		t.Fatalf("expected creation on c2 to fail, got %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "/organizations/org/projects/proj/clusters/c1/users/user-id" {
+		t.Fatalf("expected the user to be rolled back on c1 only, deleted %v", deleted)
+	}
+}
+
+func TestDeleteUser_RetryAfterPartialFailure(t *testing.T) {
+	users := map[string]bool{"c1": true, "c2": true}
+	failC2 := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/organizations/org/apikeys/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		parts := strings.Split(r.URL.Path, "/")
+		cluster := parts[6]
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/allowedcidrs"):
+			w.Write([]byte(`{"data":[]}`))
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/users"):
+			if users[cluster] {
+				w.Write([]byte(`{"data":[{"id":"user-id","name":"V_USER"}],"cursor":{"pages":{"page":1}}}`))
+				return
+			}
+			w.Write([]byte(`{"data":[],"cursor":{"pages":{"page":1}}}`))
+		case r.Method == http.MethodDelete && cluster == "c2" && failC2:
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.Method == http.MethodDelete:
+			users[cluster] = false
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	cp := newTestConnectionProducer(srv.URL)
+	cp.clusterIDs = []string{"c1", "c2"}
+	db := &CouchbaseCapellaDB{couchbaseCapellaDBConnectionProducer: cp, logger: hclog.NewNullLogger()}
+
+	_, err := db.DeleteUser(context.Background(), dbplugin.DeleteUserRequest{Username: "V_USER"})
+	if err == nil || !strings.Contains(err.Error(), "cluster c2") {
+		t.Fatalf("expected the deletion on c2 to fail, got %v", err)
+	}
+	if users["c1"] || !users["c2"] {
+		t.Fatalf("expected the user to be deleted on c1 only, remaining %v", users)
+	}
+
+	failC2 = false
+	_, err = db.DeleteUser(context.Background(), dbplugin.DeleteUserRequest{Username: "V_USER"})
+	if err != nil {
+		t.Fatalf("expected the retried revocation to succeed, got %v", err)
+	}
+	if users["c2"] {
+		t.Fatalf("expected the user to be deleted on c2")
+	}
+}
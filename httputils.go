@@ -3,6 +3,7 @@ package couchbasecapella
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -13,7 +14,6 @@ import (
 
 	"github.com/cenkalti/backoff"
 	"github.com/hashicorp/go-hclog"
-	"github.com/hashicorp/go-version"
 
 	"bytes"
 	"crypto/hmac"
@@ -23,42 +23,6 @@ import (
 	"strconv"
 )
 
-func CheckForOldCouchbaseCapellaVersion(hostname, username, password string) (is_old bool, err error) {
-
-	//[TODO] handle list of hostnames
-
-	resp, err := http.Get(fmt.Sprintf("http://%s:%s@%s:8091/pools", username, password, hostname))
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return false, err
-	}
-
-	type Pools struct {
-		ImplementationVersion string `json:"implementationVersion"`
-	}
-	data := Pools{}
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		return false, err
-	}
-	v, err := version.NewVersion(data.ImplementationVersion)
-
-	v650, err := version.NewVersion("6.5.0-0000")
-	if err != nil {
-		return false, err
-	}
-
-	if v.LessThan(v650) {
-		return true, nil
-	}
-	return false, nil
-
-}
-
 func getRootCAfromCouchbaseCapella(url string) (Base64pemCA string, err error) {
 	resp, err := http.Get(url)
 	if err != nil {
@@ -72,52 +36,6 @@ func getRootCAfromCouchbaseCapella(url string) (Base64pemCA string, err error) {
 	return base64.StdEncoding.EncodeToString(body), nil
 }
 
-func createUser(hostname string, port int, adminuser, adminpassword, username, password, rbacName, roles string) (err error) {
-	v := url.Values{}
-
-	v.Set("password", password)
-	v.Add("roles", roles)
-	v.Add("name", rbacName)
-
-	req, err := http.NewRequest(http.MethodPut,
-		fmt.Sprintf("http://%s:%s@%s:%d/settings/rbac/users/local/%s",
-			adminuser, adminpassword, hostname, port, username),
-		strings.NewReader(v.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("createUser returned %s", resp.Status)
-	}
-	return nil
-}
-
-func createGroup(hostname string, port int, adminuser, adminpassword, group, roles string) (err error) {
-	v := url.Values{}
-
-	v.Set("roles", roles)
-
-	req, err := http.NewRequest(http.MethodPut,
-		fmt.Sprintf("http://%s:%s@%s:%d/settings/rbac/groups/%s",
-			adminuser, adminpassword, hostname, port, group),
-		strings.NewReader(v.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("createGroup returned %s", resp.Status)
-	}
-	return nil
-}
-
 func waitForBucket(t *testing.T, address, username, password, bucketName string) {
 	t.Logf("Waiting for bucket %s...", bucketName)
 	f := func() error {
@@ -191,20 +109,30 @@ type CapellaClient struct {
 	logger     hclog.Logger
 }
 
+// capellaHTTPClient is shared by every CapellaClient. It is configured once
+// here, as requests may be sent concurrently.
+var capellaHTTPClient = func() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return &http.Client{Timeout: 30 * time.Second, Transport: transport}
+}()
+
+// capellaVerifyingHTTPClient verifies the certificate of the management API.
+// It is used for requests whose answer is trusted without a further check,
+// such as the cluster CA certificate that data-plane connections trust.
+var capellaVerifyingHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
 func NewClient(baseURL, access, secret string) *CapellaClient {
 	return &CapellaClient{
 		baseURL:    baseURL,
 		access:     access,
 		secret:     secret,
-		httpClient: http.DefaultClient,
+		httpClient: capellaHTTPClient,
 		logger:     hclog.New(&hclog.LoggerOptions{}),
 	}
 }
 
 func (c *CapellaClient) sendRequest(method string, url string, payload string) (*http.Response, error) {
-	c.httpClient.Timeout = 30 * time.Second
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-
 	//log.Printf("\n\n\t%s %s\n\tAuthorization: %s\n\t%s\n", method, url, authToken, payload)
 	req, err := http.NewRequest(method, url, bytes.NewBuffer([]byte(payload)))
 	if err != nil {
@@ -224,8 +152,6 @@ func (c *CapellaClient) sendRequest(method string, url string, payload string) (
 		}
 	}
 
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-
 	return c.httpClient.Do(req)
 
 }
@@ -276,8 +202,629 @@ func Unmarshal(body io.Reader, v interface{}) error {
 	return json.Unmarshal(rb, v)
 }
 
+// CapellaAPIError is returned when the Capella management API answers with an
+// unexpected HTTP status.
+type CapellaAPIError struct {
+	Method     string
+	Endpoint   string
+	StatusCode int
+	Body       string
+}
+
+func (e *CapellaAPIError) Error() string {
+	return fmt.Sprintf("capella api %s %s returned %d, response = %s", e.Method, e.Endpoint, e.StatusCode, e.Body)
+}
+
+// doJSON sends the request and, when the response status matches
+// expectedStatus, decodes the JSON body into v (if v is not nil). Any other
+// status is returned as a *CapellaAPIError.
+func (c *CapellaClient) doJSON(method string, ep string, payload string, expectedStatus int, v interface{}) error {
+	resp, err := c.sendRequest(method, ep, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed reading capella response, ep = %s, error = %v", ep, err)
+	}
+	if resp.StatusCode != expectedStatus {
+		return &CapellaAPIError{Method: method, Endpoint: ep, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	if v == nil || len(body) == 0 {
+		return nil
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("failed during capella response unmarshal, ep = %s, error = %v", ep, err)
+	}
+	return nil
+}
+
 // --
 
+// CapellaCluster is the subset of the Capella v4 cluster resource used by the plugin.
+type CapellaCluster struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	CurrentState     string `json:"currentState"`
+	ConnectionString string `json:"connectionString"`
+	CouchbaseServer  struct {
+		Version string `json:"version"`
+	} `json:"couchbaseServer"`
+}
+
+// GetCapellaCluster fetches the cluster addressed by cloudAPIclustersEndPoint.
+func GetCapellaCluster(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string) (*CapellaCluster, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	var cluster CapellaCluster
+	err := c.doJSON(http.MethodGet, c.baseURL+cloudAPIclustersEndPoint, "", http.StatusOK, &cluster)
+	if err != nil {
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+// TurnOnCapellaCluster asks Capella to turn on the cluster addressed by
+// cloudAPIclustersEndPoint. The call returns as soon as the request is accepted.
+func TurnOnCapellaCluster(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string) error {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	ep := c.baseURL + cloudAPIclustersEndPoint + "/activationState"
+	return c.doJSON(http.MethodPost, ep, `{"turnOnLinkedAppService":true}`, http.StatusAccepted, nil)
+}
+
+// GetCapellaClusterCertificate returns the PEM encoded CA certificate of the
+// cluster addressed by cloudAPIclustersEndPoint.
+func GetCapellaClusterCertificate(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string) (string, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+	c.httpClient = capellaVerifyingHTTPClient
+
+	var content struct {
+		Certificate string `json:"certificate"`
+	}
+	err := c.doJSON(http.MethodGet, c.baseURL+cloudAPIclustersEndPoint+"/certificates", "", http.StatusOK, &content)
+	if err != nil {
+		return "", err
+	}
+	return content.Certificate, nil
+}
+
+// ListCapellaClusters returns every cluster of the collection
+// cloudAPIclustersCollection, the clusters or the Columnar instances of a project.
+func ListCapellaClusters(baseUrl string, cloudAPIclustersCollection string, accessKey string, secretKey string) ([]CapellaCluster, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	var clusters []CapellaCluster
+	for page := 1; ; {
+		var content struct {
+			Cursor Cursor           `json:"cursor"`
+			Data   []CapellaCluster `json:"data"`
+		}
+		ep := fmt.Sprintf("%s%s?page=%d&perPage=100", c.baseURL, cloudAPIclustersCollection, page)
+		err := c.doJSON(http.MethodGet, ep, "", http.StatusOK, &content)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, content.Data...)
+		if content.Cursor.Pages.Next == nil {
+			return clusters, nil
+		}
+		page = *content.Cursor.Pages.Next
+	}
+}
+
+// GetCapellaOrganization reads the organization orgID. It is the cheapest call
+// that proves a key is accepted by Capella.
+func GetCapellaOrganization(baseUrl string, orgID string, accessKey string, secretKey string) error {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	return c.doJSON(http.MethodGet, c.baseURL+"/organizations/"+orgID, "", http.StatusOK, nil)
+}
+
+// CapellaProject is the subset of a Capella project the plugin reads.
+type CapellaProject struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// GetCapellaProject reads a project of an organization.
+func GetCapellaProject(baseUrl string, orgID string, projectID string, accessKey string, secretKey string) (*CapellaProject, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	var project CapellaProject
+	err := c.doJSON(http.MethodGet, c.baseURL+"/organizations/"+orgID+"/projects/"+projectID, "", http.StatusOK, &project)
+	if err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// CapellaAPIKeyResource is a resource (currently always a project) an API key
+// is scoped to, together with the roles granted on it.
+type CapellaAPIKeyResource struct {
+	ID    string   `json:"id"`
+	Type  string   `json:"type"`
+	Roles []string `json:"roles"`
+}
+
+// CapellaAPIKey is the subset of the Capella v4 API key resource used by the plugin.
+type CapellaAPIKey struct {
+	ID                string                  `json:"id"`
+	Name              string                  `json:"name"`
+	Description       string                  `json:"description"`
+	Expiry            float64                 `json:"expiry"`
+	AllowedCIDRs      []string                `json:"allowedCIDRs"`
+	OrganizationRoles []string                `json:"organizationRoles"`
+	Resources         []CapellaAPIKeyResource `json:"resources"`
+	Audit             struct {
+		CreatedAt time.Time `json:"createdAt"`
+	} `json:"audit"`
+}
+
+// GetCapellaAPIKey fetches the API key apiKeyID of the organization orgID.
+func GetCapellaAPIKey(baseUrl string, orgID string, accessKey string, secretKey string, apiKeyID string) (*CapellaAPIKey, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	var key CapellaAPIKey
+	ep := c.baseURL + "/organizations/" + orgID + "/apikeys/" + apiKeyID
+	err := c.doJSON(http.MethodGet, ep, "", http.StatusOK, &key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// CapellaAPIKeyRequest is the payload used to create a Capella v4 API key.
+type CapellaAPIKeyRequest struct {
+	Name              string                  `json:"name"`
+	Description       string                  `json:"description,omitempty"`
+	Expiry            float64                 `json:"expiry,omitempty"`
+	AllowedCIDRs      []string                `json:"allowedCIDRs,omitempty"`
+	OrganizationRoles []string                `json:"organizationRoles"`
+	Resources         []CapellaAPIKeyResource `json:"resources,omitempty"`
+}
+
+// CreateCapellaAPIKey creates an API key in the organization orgID and returns
+// its access key and secret.
+func CreateCapellaAPIKey(baseUrl string, orgID string, accessKey string, secretKey string, key CapellaAPIKeyRequest) (string, string, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed during capella api key creation, marshal error = %v", err)
+	}
+
+	var content struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	ep := c.baseURL + "/organizations/" + orgID + "/apikeys"
+	err = c.doJSON(http.MethodPost, ep, string(data), http.StatusCreated, &content)
+	if err != nil {
+		return "", "", err
+	}
+
+	// The token is the bearer token of the key, base64 of "<access>:<secret>".
+	token, err := base64.StdEncoding.DecodeString(content.Token)
+	if err != nil {
+		return "", "", fmt.Errorf("failed during capella api key creation, unable to decode the token of key %s: %v", content.ID, err)
+	}
+	secret := strings.TrimPrefix(string(token), content.ID+":")
+	return content.ID, secret, nil
+}
+
+// DeleteCapellaAPIKey deletes the API key apiKeyID of the organization orgID.
+func DeleteCapellaAPIKey(baseUrl string, orgID string, accessKey string, secretKey string, apiKeyID string) error {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	ep := c.baseURL + "/organizations/" + orgID + "/apikeys/" + apiKeyID
+	return c.doJSON(http.MethodDelete, ep, "", http.StatusNoContent, nil)
+}
+
+// CapellaAllowedCIDR is an entry of the allowed CIDR list of a cluster.
+type CapellaAllowedCIDR struct {
+	ID        string `json:"id,omitempty"`
+	Cidr      string `json:"cidr"`
+	Comment   string `json:"comment,omitempty"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+// CreateAllowedCIDR adds an entry to the allowed CIDR list of the cluster
+// cloudAPIclustersEndPoint and returns its ID.
+func CreateAllowedCIDR(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string, cidr CapellaAllowedCIDR) (string, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	data, err := json.Marshal(cidr)
+	if err != nil {
+		return "", fmt.Errorf("failed during allowed cidr creation, marshal error = %v, cidr = %s", err, cidr.Cidr)
+	}
+	var content struct {
+		ID string `json:"id"`
+	}
+	ep := c.baseURL + cloudAPIclustersEndPoint + "/allowedcidrs"
+	err = c.doJSON(http.MethodPost, ep, string(data), http.StatusCreated, &content)
+	if err != nil {
+		return "", err
+	}
+	return content.ID, nil
+}
+
+// ListAllowedCIDRs returns the allowed CIDR list of the cluster cloudAPIclustersEndPoint.
+func ListAllowedCIDRs(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string) ([]CapellaAllowedCIDR, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	var cidrs []CapellaAllowedCIDR
+	for page := 1; ; {
+		var content struct {
+			Cursor Cursor               `json:"cursor"`
+			Data   []CapellaAllowedCIDR `json:"data"`
+		}
+		ep := fmt.Sprintf("%s%s/allowedcidrs?page=%d&perPage=100", c.baseURL, cloudAPIclustersEndPoint, page)
+		err := c.doJSON(http.MethodGet, ep, "", http.StatusOK, &content)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, content.Data...)
+		if content.Cursor.Pages.Next == nil {
+			return cidrs, nil
+		}
+		page = *content.Cursor.Pages.Next
+	}
+}
+
+// DeleteAllowedCIDR removes the entry cidrID from the allowed CIDR list of the
+// cluster cloudAPIclustersEndPoint.
+func DeleteAllowedCIDR(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string, cidrID string) error {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	ep := c.baseURL + cloudAPIclustersEndPoint + "/allowedcidrs/" + cidrID
+	return c.doJSON(http.MethodDelete, ep, "", http.StatusNoContent, nil)
+}
+
+// Couchbase Server REST utils
+// ---------------------------
+
+// CouchbaseRESTClient talks to the REST API of a self-managed Couchbase
+// Server cluster with basic authentication.
+type CouchbaseRESTClient struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+func NewCouchbaseRESTClient(baseURL, username, password string, tlsConfig *tls.Config) *CouchbaseRESTClient {
+	return &CouchbaseRESTClient{
+		baseURL:  baseURL,
+		username: username,
+		password: password,
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}
+}
+
+// CouchbaseRESTError is returned when Couchbase Server answers with an
+// unexpected HTTP status.
+type CouchbaseRESTError struct {
+	Method     string
+	Endpoint   string
+	StatusCode int
+	Body       string
+}
+
+func (e *CouchbaseRESTError) Error() string {
+	return fmt.Sprintf("couchbase rest %s %s returned %d, response = %s", e.Method, e.Endpoint, e.StatusCode, e.Body)
+}
+
+// doForm sends form as a url encoded body and decodes a JSON response into v
+// (if v is not nil). Any status other than 200 is returned as a
+// *CouchbaseRESTError.
+func (c *CouchbaseRESTClient) doForm(method string, path string, form url.Values, v interface{}) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	if form != nil {
+		req.Header.Set(headerKeyContentType, "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed reading couchbase response, path = %s, error = %v", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &CouchbaseRESTError{Method: method, Endpoint: path, StatusCode: resp.StatusCode, Body: string(b)}
+	}
+	if v == nil || len(b) == 0 {
+		return nil
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("failed during couchbase response unmarshal, path = %s, error = %v", path, err)
+	}
+	return nil
+}
+
+// RBACRole is a role assignment of a Couchbase Server user.
+type RBACRole struct {
+	Role           string `json:"role"`
+	BucketName     string `json:"bucket_name,omitempty"`
+	ScopeName      string `json:"scope_name,omitempty"`
+	CollectionName string `json:"collection_name,omitempty"`
+	Origins        []struct {
+		Type string `json:"type"`
+		Name string `json:"name,omitempty"`
+	} `json:"origins,omitempty"`
+}
+
+// Spec returns the role in the role[bucket:scope:collection] form the REST
+// API accepts.
+func (r RBACRole) Spec() string {
+	var params []string
+	for _, p := range []string{r.BucketName, r.ScopeName, r.CollectionName} {
+		if p == "" {
+			break
+		}
+		params = append(params, p)
+	}
+	if len(params) == 0 {
+		return r.Role
+	}
+	return r.Role + "[" + strings.Join(params, ":") + "]"
+}
+
+// direct reports whether the role is assigned to the user itself rather than
+// inherited from one of its groups.
+func (r RBACRole) direct() bool {
+	if len(r.Origins) == 0 {
+		return true
+	}
+	for _, o := range r.Origins {
+		if o.Type == "user" {
+			return true
+		}
+	}
+	return false
+}
+
+// RBACUser is a local Couchbase Server user.
+type RBACUser struct {
+	ID     string     `json:"id"`
+	Name   string     `json:"name"`
+	Roles  []RBACRole `json:"roles"`
+	Groups []string   `json:"groups"`
+}
+
+// DirectRoles returns the specs of the roles assigned to the user itself.
+func (u *RBACUser) DirectRoles() []string {
+	var roles []string
+	for _, r := range u.Roles {
+		if r.direct() {
+			roles = append(roles, r.Spec())
+		}
+	}
+	return roles
+}
+
+// UpsertRBACUser creates or replaces the local user username. An empty
+// password keeps the password of an existing user.
+func (c *CouchbaseRESTClient) UpsertRBACUser(username string, password string, roles []string, groups []string) error {
+	v := url.Values{}
+	if password != "" {
+		v.Set("password", password)
+	}
+	v.Set("roles", strings.Join(roles, ","))
+	if len(groups) > 0 {
+		v.Set("groups", strings.Join(groups, ","))
+	}
+	return c.doForm(http.MethodPut, "/settings/rbac/users/local/"+url.PathEscape(username), v, nil)
+}
+
+// GetRBACUser reads the local user username.
+func (c *CouchbaseRESTClient) GetRBACUser(username string) (*RBACUser, error) {
+	var user RBACUser
+	err := c.doForm(http.MethodGet, "/settings/rbac/users/local/"+url.PathEscape(username), nil, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListRBACUsers returns every local user.
+func (c *CouchbaseRESTClient) ListRBACUsers() ([]RBACUser, error) {
+	var users []RBACUser
+	err := c.doForm(http.MethodGet, "/settings/rbac/users/local", nil, &users)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// DeleteRBACUser deletes the local user username.
+func (c *CouchbaseRESTClient) DeleteRBACUser(username string) error {
+	return c.doForm(http.MethodDelete, "/settings/rbac/users/local/"+url.PathEscape(username), nil, nil)
+}
+
+// RBACGroup is a Couchbase Server user group.
+type RBACGroup struct {
+	ID          string     `json:"id"`
+	Description string     `json:"description"`
+	Roles       []RBACRole `json:"roles"`
+}
+
+// GetRBACGroup reads the group.
+func (c *CouchbaseRESTClient) GetRBACGroup(group string) (*RBACGroup, error) {
+	var g RBACGroup
+	err := c.doForm(http.MethodGet, "/settings/rbac/groups/"+url.PathEscape(group), nil, &g)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ChangePassword changes the password of the authenticated user.
+func (c *CouchbaseRESTClient) ChangePassword(password string) error {
+	v := url.Values{}
+	v.Set("password", password)
+	return c.doForm(http.MethodPost, "/controller/changePassword", v, nil)
+}
+
+// Version returns the implementation version reported by /pools, such as
+// 7.2.4-7070-enterprise.
+func (c *CouchbaseRESTClient) Version() (string, error) {
+	var pools struct {
+		ImplementationVersion string `json:"implementationVersion"`
+	}
+	err := c.doForm(http.MethodGet, "/pools", nil, &pools)
+	if err != nil {
+		return "", err
+	}
+	return pools.ImplementationVersion, nil
+}
+
+// WhoAmI reads the authenticated user, which proves the credentials are
+// accepted.
+func (c *CouchbaseRESTClient) WhoAmI() error {
+	return c.doForm(http.MethodGet, "/whoami", nil, nil)
+}
+
+// AppEndpointUser is an App Services App Endpoint user.
+type AppEndpointUser struct {
+	Name          string   `json:"name"`
+	Password      string   `json:"password,omitempty"`
+	AdminChannels []string `json:"adminChannels"`
+	AdminRoles    []string `json:"adminRoles"`
+}
+
+func appEndpointUsersPath(cloudAPIclustersEndPoint string, appServiceID string, appEndpoint string) string {
+	return cloudAPIclustersEndPoint + "/appservices/" + appServiceID + "/appEndpoints/" + url.PathEscape(appEndpoint) + "/users"
+}
+
+// CreateAppEndpointUser creates user on the App Endpoint appEndpoint of the
+// App Service appServiceID linked to the cluster cloudAPIclustersEndPoint.
+func CreateAppEndpointUser(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string,
+	appServiceID string, appEndpoint string, user AppEndpointUser) error {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed during app endpoint user creation, marshal error = %v, user = %s", err, user.Name)
+	}
+	ep := c.baseURL + appEndpointUsersPath(cloudAPIclustersEndPoint, appServiceID, appEndpoint)
+	return c.doJSON(http.MethodPost, ep, string(data), http.StatusCreated, nil)
+}
+
+// GetAppEndpointUser fetches username from an App Endpoint.
+func GetAppEndpointUser(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string,
+	appServiceID string, appEndpoint string, username string) (*AppEndpointUser, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	var user AppEndpointUser
+	ep := c.baseURL + appEndpointUsersPath(cloudAPIclustersEndPoint, appServiceID, appEndpoint) + "/" + url.PathEscape(username)
+	err := c.doJSON(http.MethodGet, ep, "", http.StatusOK, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateAppEndpointUser replaces the password, channels and roles of an App Endpoint user.
+func UpdateAppEndpointUser(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string,
+	appServiceID string, appEndpoint string, user AppEndpointUser) error {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed during app endpoint user update, marshal error = %v, user = %s", err, user.Name)
+	}
+	ep := c.baseURL + appEndpointUsersPath(cloudAPIclustersEndPoint, appServiceID, appEndpoint) + "/" + url.PathEscape(user.Name)
+	return c.doJSON(http.MethodPut, ep, string(data), http.StatusNoContent, nil)
+}
+
+// DeleteAppEndpointUser deletes username from an App Endpoint.
+func DeleteAppEndpointUser(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string,
+	appServiceID string, appEndpoint string, username string) error {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	ep := c.baseURL + appEndpointUsersPath(cloudAPIclustersEndPoint, appServiceID, appEndpoint) + "/" + url.PathEscape(username)
+	return c.doJSON(http.MethodDelete, ep, "", http.StatusNoContent, nil)
+}
+
+// CapellaAppService is the subset of a Capella App Service the plugin reads.
+type CapellaAppService struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	ClusterID string `json:"clusterId"`
+}
+
+// ListCapellaAppServices returns every App Service of the organization orgID.
+func ListCapellaAppServices(baseUrl string, orgID string, accessKey string, secretKey string) ([]CapellaAppService, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	var services []CapellaAppService
+	for page := 1; ; {
+		var content struct {
+			Cursor Cursor              `json:"cursor"`
+			Data   []CapellaAppService `json:"data"`
+		}
+		ep := fmt.Sprintf("%s/organizations/%s/appservices?page=%d&perPage=100", c.baseURL, orgID, page)
+		err := c.doJSON(http.MethodGet, ep, "", http.StatusOK, &content)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, content.Data...)
+		if content.Cursor.Pages.Next == nil {
+			return services, nil
+		}
+		page = *content.Cursor.Pages.Next
+	}
+}
+
+// CapellaAppEndpoint is the subset of an App Endpoint the plugin reads.
+type CapellaAppEndpoint struct {
+	Name string `json:"name"`
+}
+
+// ListAppEndpoints returns every App Endpoint of the App Service appServiceID
+// linked to the cluster cloudAPIclustersEndPoint.
+func ListAppEndpoints(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string, appServiceID string) ([]CapellaAppEndpoint, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	var endpoints []CapellaAppEndpoint
+	for page := 1; ; {
+		var content struct {
+			Cursor Cursor               `json:"cursor"`
+			Data   []CapellaAppEndpoint `json:"data"`
+		}
+		ep := fmt.Sprintf("%s%s/appservices/%s/appEndpoints?page=%d&perPage=100", c.baseURL, cloudAPIclustersEndPoint, appServiceID, page)
+		err := c.doJSON(http.MethodGet, ep, "", http.StatusOK, &content)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, content.Data...)
+		if content.Cursor.Pages.Next == nil {
+			return endpoints, nil
+		}
+		page = *content.Cursor.Pages.Next
+	}
+}
+
 func CreateCapellaDbCredUser(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey,
 	username string, password string, access string) error {
 
@@ -343,35 +890,43 @@ func UpdateCapellaDbCredUser(baseUrl string, cloudAPIclustersEndPoint string, ac
 
 	} else { // secret key rotation
 		apiPathSlices := strings.Split(cloudAPIclustersEndPoint, "/")
-		ep := c.baseURL + "/organizations/" + apiPathSlices[2] + "/apikeys/" + username + "/rotate"
-		data := fmt.Sprintf("{\"secret\":\"%s\"}", password)
-		c.logger.Info(fmt.Sprintf("%s %s", http.MethodPost, ep))
-		resp, err := c.sendRequest(http.MethodPost, ep, data)
-		if resp != nil && resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("failed during capella secret key rotate, response = %v, ep = %s",
-				resp, ep)
-		}
-		if err != nil {
-			return "", err
-		}
-		defer resp.Body.Close()
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return "", fmt.Errorf("failed during capella user id fetch unmarshal, response = %v, ep = %s, error=%v",
-				resp, ep, err)
-		}
-		var content map[string]string
-		err = json.Unmarshal([]byte(body), &content)
-		if err != nil {
-			return "", fmt.Errorf("failed during capella user id fetch unmarshal, response = %v, ep = %s, error=%v",
-				resp, ep, err)
-		}
-		return content["secretKey"], nil
+		return RotateCapellaAPIKey(baseUrl, apiPathSlices[2], accessKey, secretKey, username, password)
 	}
 	return "", nil
 }
 
+// RotateCapellaAPIKey sets the secret of the API key apiKeyID of the
+// organization orgID and returns the secret reported by Capella.
+func RotateCapellaAPIKey(baseUrl string, orgID string, accessKey string, secretKey string, apiKeyID string, newSecret string) (string, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	ep := c.baseURL + "/organizations/" + orgID + "/apikeys/" + apiKeyID + "/rotate"
+	data := fmt.Sprintf("{\"secret\":\"%s\"}", newSecret)
+	c.logger.Info(fmt.Sprintf("%s %s", http.MethodPost, ep))
+	resp, err := c.sendRequest(http.MethodPost, ep, data)
+	if resp != nil && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed during capella secret key rotate, response = %v, ep = %s",
+			resp, ep)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed during capella user id fetch unmarshal, response = %v, ep = %s, error=%v",
+			resp, ep, err)
+	}
+	var content map[string]string
+	err = json.Unmarshal([]byte(body), &content)
+	if err != nil {
+		return "", fmt.Errorf("failed during capella user id fetch unmarshal, response = %v, ep = %s, error=%v",
+			resp, ep, err)
+	}
+	return content["secretKey"], nil
+}
+
 func DeleteCapellaDbCredUser(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey, username string) error {
 	c := NewCapellaClient(baseUrl, accessKey, secretKey)
 
@@ -422,17 +977,62 @@ type Cursor struct {
 }
 
 type ListDbCredResponse struct {
-	Cursor Cursor        `json: "cursor"`
+	Cursor Cursor        `json:"cursor"`
 	Data   []interface{} `json:"data"`
 }
 
+// CapellaDbCredUser is a database credential of a cluster.
+type CapellaDbCredUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ListCapellaDbCredUsers returns every database credential of the cluster
+// cloudAPIclustersEndPoint.
+func ListCapellaDbCredUsers(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string) ([]CapellaDbCredUser, error) {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	var users []CapellaDbCredUser
+	for page := 1; ; {
+		var content struct {
+			Cursor Cursor              `json:"cursor"`
+			Data   []CapellaDbCredUser `json:"data"`
+		}
+		ep := fmt.Sprintf("%s%s/users?page=%d&perPage=100", c.baseURL, cloudAPIclustersEndPoint, page)
+		err := c.doJSON(http.MethodGet, ep, "", http.StatusOK, &content)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, content.Data...)
+		if content.Cursor.Pages.Next == nil {
+			return users, nil
+		}
+		page = *content.Cursor.Pages.Next
+	}
+}
+
+// DeleteCapellaDbCredUserByID deletes the database credential userID of the
+// cluster cloudAPIclustersEndPoint.
+func DeleteCapellaDbCredUserByID(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey string, userID string) error {
+	c := NewCapellaClient(baseUrl, accessKey, secretKey)
+
+	ep := c.baseURL + cloudAPIclustersEndPoint + "/users/" + userID
+	return c.doJSON(http.MethodDelete, ep, "", http.StatusNoContent, nil)
+}
+
+// ErrDbCredUserNotFound is returned when a database credential does not exist on a cluster.
+var ErrDbCredUserNotFound = errors.New("db user id is not found for the given username")
+
 func getDbCredId(baseUrl string, cloudAPIclustersEndPoint string, accessKey string, secretKey, username string) (string, error) {
 	c := NewCapellaClient(baseUrl, accessKey, secretKey)
 	dbUserId := ""
 	page := 1
 	ep := fmt.Sprintf("%s%s/users?page=%d&perPage=100", c.baseURL, cloudAPIclustersEndPoint, page)
 	for page > 0 {
-		resp, _ := c.sendRequest(http.MethodGet, ep, "")
+		resp, err := c.sendRequest(http.MethodGet, ep, "")
+		if err != nil {
+			return dbUserId, err
+		}
 		if resp.StatusCode != http.StatusOK {
 			return dbUserId, fmt.Errorf("failed during capella user id fetch, response = %v, ep = %s",
 				resp, ep)
@@ -465,13 +1065,12 @@ func getDbCredId(baseUrl string, cloudAPIclustersEndPoint string, accessKey stri
 				}
 			}
 			// next page
-			page = content.Cursor.Pages.Page
-			if page == 0 {
-				return dbUserId, fmt.Errorf("failed during capella user id fetch unmarshal, response = %v, ep = %s, error=%v",
-					resp, ep, "db user id is not found for the given username")
-			} else {
-				ep = content.Cursor.Hrefs.Next
+			if content.Cursor.Pages.Next == nil {
+				return dbUserId, fmt.Errorf("failed during capella user id fetch, ep = %s, user = %s: %w",
+					ep, username, ErrDbCredUserNotFound)
 			}
+			page = *content.Cursor.Pages.Next
+			ep = fmt.Sprintf("%s%s/users?page=%d&perPage=100", c.baseURL, cloudAPIclustersEndPoint, page)
 		}
 	}
 	return dbUserId, nil
@@ -0,0 +1,103 @@
+package couchbasecapella
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+)
+
+const defaultKeyExpiryCheckInterval = time.Hour
+
+// ErrRootAPIKeyExpired is returned by every operation once the root API key
+// has expired.
+var ErrRootAPIKeyExpired = errors.New("root API key expired")
+
+var defaultKeyExpiryWarnThresholds = []time.Duration{30 * 24 * time.Hour, 7 * 24 * time.Hour, 24 * time.Hour}
+
+// parseKeyExpiryThresholds parses a comma separated list of durations and
+// returns them sorted from the longest to the shortest.
+func parseKeyExpiryThresholds(raw string) ([]time.Duration, error) {
+	if len(raw) == 0 {
+		return defaultKeyExpiryWarnThresholds, nil
+	}
+
+	var thresholds []time.Duration
+	for _, s := range strings.Split(raw, ",") {
+		d, err := parseutil.ParseDurationSecond(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, d)
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] > thresholds[j] })
+	return thresholds, nil
+}
+
+// refreshKeyExpiry reads the expiry of the API key accessKey and logs a
+// warning the first time the remaining lifetime drops below each threshold.
+func (c *couchbaseCapellaDBConnectionProducer) refreshKeyExpiry(accessKey, secretKey string) {
+	key, err := GetCapellaAPIKey(c.CloudAPIBaseURL, c.OrganizationID, accessKey, secretKey, accessKey)
+	if err != nil {
+		c.logger.Warn("unable to read the expiry of the root API key", "key", accessKey, "error", err)
+		return
+	}
+
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	if key.Expiry <= 0 || key.Audit.CreatedAt.IsZero() {
+		c.keyExpiresAt = time.Time{}
+		return
+	}
+	expiresAt := key.Audit.CreatedAt.Add(time.Duration(key.Expiry * float64(24*time.Hour)))
+	if !expiresAt.Equal(c.keyExpiresAt) {
+		c.keyExpiresAt = expiresAt
+		c.keyExpiryWarned = 0
+	}
+
+	remaining := time.Until(expiresAt)
+	for i := len(c.keyExpiryThresholds) - 1; i >= c.keyExpiryWarned; i-- {
+		if remaining <= c.keyExpiryThresholds[i] {
+			c.logger.Warn("the root API key expires soon, create a new key and update the database configuration",
+				"key", accessKey, "expires_at", expiresAt, "remaining", remaining.Round(time.Minute))
+			c.keyExpiryWarned = i + 1
+			break
+		}
+	}
+}
+
+// checkKeyExpiry returns ErrRootAPIKeyExpired once the root API key has expired.
+func (c *couchbaseCapellaDBConnectionProducer) checkKeyExpiry() error {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	if !c.keyExpiresAt.IsZero() && time.Now().After(c.keyExpiresAt) {
+		return fmt.Errorf("%w: the API key %s expired at %s, create a new key and update the database configuration",
+			ErrRootAPIKeyExpired, c.Username, c.keyExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// watchKeyExpiry refreshes the expiry of the root API key every
+// key_expiry_check_interval until stop is closed.
+func (c *couchbaseCapellaDBConnectionProducer) watchKeyExpiry(stop chan struct{}) {
+	ticker := time.NewTicker(c.keyExpiryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		c.RLock()
+		accessKey, secretKey := c.Username, c.Password
+		c.RUnlock()
+		c.refreshKeyExpiry(accessKey, secretKey)
+	}
+}
@@ -0,0 +1,48 @@
+package couchbasecapella
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestConnectionProducer_KeyExpiry(t *testing.T) {
+	tests := map[string]struct {
+		createdAt   time.Time
+		expiryDays  float64
+		wantWarned  int
+		wantExpired bool
+	}{
+		"no expiry":     {createdAt: time.Now().Add(-400 * 24 * time.Hour), expiryDays: -1},
+		"far from it":   {createdAt: time.Now(), expiryDays: 180},
+		"within a week": {createdAt: time.Now().Add(-175 * 24 * time.Hour), expiryDays: 180, wantWarned: 2},
+		"expired":       {createdAt: time.Now().Add(-181 * 24 * time.Hour), expiryDays: 180, wantWarned: 3, wantExpired: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"id":"ACCESS","expiry":%v,"audit":{"createdAt":%q}}`, test.expiryDays, test.createdAt.Format(time.RFC3339))
+			}))
+			defer srv.Close()
+
+			cp := newTestConnectionProducer(srv.URL)
+			cp.logger = hclog.NewNullLogger()
+			cp.keyExpiryThresholds = defaultKeyExpiryWarnThresholds
+
+			cp.refreshKeyExpiry(cp.Username, cp.Password)
+			if cp.keyExpiryWarned != test.wantWarned {
+				t.Fatalf("expected %d thresholds warned, got %d", test.wantWarned, cp.keyExpiryWarned)
+			}
+			err := cp.checkKeyExpiry()
+			if errors.Is(err, ErrRootAPIKeyExpired) != test.wantExpired {
+				t.Fatalf("unexpected expiry check result: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package couchbasecapella
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/hashicorp/vault/sdk/helper/template"
+)
+
+const (
+	// Without allowed_cidr_networks a lease CIDR may open a cluster to at
+	// most a /24 IPv4 or a /64 IPv6 network.
+	minLeaseCIDRPrefixIPv4 = 24
+	minLeaseCIDRPrefixIPv6 = 64
+)
+
+// leaseCIDRComment tags the allowed CIDR entries added for username, which is
+// how they are found again on revocation.
+func leaseCIDRComment(username string) string {
+	return "vault:" + username
+}
+
+// renderAllowedCIDR renders the allowed_cidr template of a statement with the
+// username metadata. A single IP address is turned into a /32 or /128 CIDR.
+func renderAllowedCIDR(raw string, metadata dbplugin.UsernameMetadata) (string, error) {
+	tmpl, err := template.NewTemplate(template.Template(raw))
+	if err != nil {
+		return "", fmt.Errorf("unable to parse allowed_cidr: %w", err)
+	}
+	cidr, err := tmpl.Generate(metadata)
+	if err != nil {
+		return "", fmt.Errorf("unable to render allowed_cidr: %w", err)
+	}
+	cidr = strings.TrimSpace(cidr)
+
+	if ip := net.ParseIP(cidr); ip != nil {
+		if ip.To4() != nil {
+			return cidr + "/32", nil
+		}
+		return cidr + "/128", nil
+	}
+	if _, _, err := net.ParseCIDR(cidr); err != nil {
+		return "", fmt.Errorf("allowed_cidr %q is neither an IP address nor a CIDR", cidr)
+	}
+	return cidr, nil
+}
+
+// parseCIDRNetworks parses allowed_cidr_networks. Entries may themselves be
+// comma separated.
+func parseCIDRNetworks(raw []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range raw {
+		for _, s := range strings.Split(entry, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			_, network, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed_cidr_networks entry %q: %w", s, err)
+			}
+			networks = append(networks, network)
+		}
+	}
+	return networks, nil
+}
+
+// checkLeaseCIDR rejects a rendered allowed_cidr that lies outside of
+// allowed_cidr_networks or, without them, is broader than a /24 IPv4 or /64
+// IPv6 network. The address may come from the caller through the display
+// name, so it must not be able to open a cluster to the internet.
+func (c *couchbaseCapellaDBConnectionProducer) checkLeaseCIDR(cidr string) error {
+	_, requested, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("allowed_cidr %q is not a CIDR", cidr)
+	}
+	ones, bits := requested.Mask.Size()
+
+	if len(c.leaseCIDRNetworks) == 0 {
+		minPrefix := minLeaseCIDRPrefixIPv4
+		if bits == 8*net.IPv6len {
+			minPrefix = minLeaseCIDRPrefixIPv6
+		}
+		if ones < minPrefix {
+			return fmt.Errorf("allowed_cidr %s is broader than /%d, list the networks it may lie in with allowed_cidr_networks", cidr, minPrefix)
+		}
+		return nil
+	}
+
+	for _, network := range c.leaseCIDRNetworks {
+		networkOnes, networkBits := network.Mask.Size()
+		if networkBits == bits && networkOnes <= ones && network.Contains(requested.IP) {
+			return nil
+		}
+	}
+	return fmt.Errorf("allowed_cidr %s is not within the allowed_cidr_networks of the database configuration", cidr)
+}
+
+// addLeaseCIDRs adds cidr to the allowed CIDR list of every cluster until
+// expiration. On failure the entries already added are removed again.
+func addLeaseCIDRs(c *couchbaseCapellaDBConnectionProducer, clusterIDs []string, username string, cidr string, expiration time.Time) error {
+	entry := CapellaAllowedCIDR{
+		Cidr:    cidr,
+		Comment: leaseCIDRComment(username),
+	}
+	if !expiration.IsZero() {
+		entry.ExpiresAt = expiration.UTC().Format(time.RFC3339)
+	}
+
+	added := map[string]string{}
+	for _, clusterID := range clusterIDs {
+		id, err := CreateAllowedCIDR(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password, entry)
+		if err != nil {
+			for addedCluster, addedID := range added {
+				if delErr := DeleteAllowedCIDR(c.CloudAPIBaseURL, c.clustersPath(addedCluster), c.Username, c.Password, addedID); delErr != nil {
+					c.logger.Error("failed to roll back allowed cidr", "cidr", cidr, "cluster", addedCluster, "error", delErr)
+				}
+			}
+			return fmt.Errorf("failed to allow %s on cluster %s: %w", cidr, clusterID, err)
+		}
+		added[clusterID] = id
+	}
+	return nil
+}
+
+// removeLeaseCIDRs removes the allowed CIDR entries added for username from
+// every cluster.
+func removeLeaseCIDRs(c *couchbaseCapellaDBConnectionProducer, clusterIDs []string, username string) error {
+	var errs []error
+	for _, clusterID := range clusterIDs {
+		cidrs, err := ListAllowedCIDRs(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", clusterID, err))
+			continue
+		}
+		for _, cidr := range cidrs {
+			if cidr.Comment != leaseCIDRComment(username) {
+				continue
+			}
+			err := DeleteAllowedCIDR(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password, cidr.ID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("cluster %s: %w", clusterID, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// extendLeaseCIDRs moves the expiry of the allowed CIDR entries added for
+// username to expiration, so that network access lasts as long as a renewed
+// lease. Capella cannot update an entry, so each one is replaced. The new
+// entry is created before the old one is deleted, so access is never lost;
+// if the creation fails the old entry is kept.
+func extendLeaseCIDRs(c *couchbaseCapellaDBConnectionProducer, clusterIDs []string, username string, expiration time.Time) error {
+	var errs []error
+	for _, clusterID := range clusterIDs {
+		clustersPath := c.clustersPath(clusterID)
+		cidrs, err := ListAllowedCIDRs(c.CloudAPIBaseURL, clustersPath, c.Username, c.Password)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", clusterID, err))
+			continue
+		}
+		for _, cidr := range cidrs {
+			if cidr.Comment != leaseCIDRComment(username) {
+				continue
+			}
+			renewed := CapellaAllowedCIDR{Cidr: cidr.Cidr, Comment: cidr.Comment, ExpiresAt: expiration.UTC().Format(time.RFC3339)}
+			_, err := CreateAllowedCIDR(c.CloudAPIBaseURL, clustersPath, c.Username, c.Password, renewed)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to extend %s on cluster %s: %w", cidr.Cidr, clusterID, err))
+				continue
+			}
+			err = DeleteAllowedCIDR(c.CloudAPIBaseURL, clustersPath, c.Username, c.Password, cidr.ID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove the previous entry of %s on cluster %s: %w", cidr.Cidr, clusterID, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
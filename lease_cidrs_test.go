@@ -0,0 +1,197 @@
+package couchbasecapella
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+)
+
+func TestRenderAllowedCIDR(t *testing.T) {
+	tests := map[string]struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		"cidr":        {raw: "10.0.0.0/24", want: "10.0.0.0/24"},
+		"ipv4":        {raw: "203.0.113.7", want: "203.0.113.7/32"},
+		"ipv6":        {raw: "2001:db8::1", want: "2001:db8::1/128"},
+		"template":    {raw: "{{.DisplayName}}", want: "198.51.100.2/32"},
+		"not an addr": {raw: "{{.RoleName}}", wantErr: true},
+		"bad syntax":  {raw: "{{.DisplayName", wantErr: true},
+	}
+
+	metadata := dbplugin.UsernameMetadata{DisplayName: "198.51.100.2", RoleName: "ci-runner"}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := renderAllowedCIDR(test.raw, metadata)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if got != test.want {
+				t.Fatalf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestConnectionProducer_CheckLeaseCIDR(t *testing.T) {
+	tests := map[string]struct {
+		networks []string
+		cidr     string
+		wantErr  bool
+	}{
+		"single ipv4":           {cidr: "203.0.113.7/32"},
+		"ipv4 /24":              {cidr: "203.0.113.0/24"},
+		"ipv4 too broad":        {cidr: "203.0.0.0/16", wantErr: true},
+		"everything":            {cidr: "0.0.0.0/0", wantErr: true},
+		"ipv6 /64":              {cidr: "2001:db8::/64"},
+		"ipv6 too broad":        {cidr: "2001:db8::/32", wantErr: true},
+		"within network":        {networks: []string{"10.0.0.0/8, 192.168.0.0/16"}, cidr: "10.20.0.0/16"},
+		"outside network":       {networks: []string{"10.0.0.0/8"}, cidr: "203.0.113.7/32", wantErr: true},
+		"broader than network":  {networks: []string{"10.0.0.0/8"}, cidr: "10.0.0.0/7", wantErr: true},
+		"other address family":  {networks: []string{"10.0.0.0/8"}, cidr: "2001:db8::1/128", wantErr: true},
+		"everything in network": {networks: []string{"0.0.0.0/0"}, cidr: "0.0.0.0/0"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			networks, err := parseCIDRNetworks(test.networks)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			cp := &couchbaseCapellaDBConnectionProducer{leaseCIDRNetworks: networks}
+			err = cp.checkLeaseCIDR(test.cidr)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+		})
+	}
+}
+
+// newLeaseCIDRTestServer serves the allowed CIDR list of cluster, holding the
+// entries in cidrs, and a cluster without database credentials.
+func newLeaseCIDRTestServer(t *testing.T, cidrs map[string]CapellaAllowedCIDR) *httptest.Server {
+	const cidrsPath = "/organizations/org/projects/proj/clusters/cluster/allowedcidrs"
+	next := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == cidrsPath:
+			var data []CapellaAllowedCIDR
+			for _, cidr := range cidrs {
+				data = append(data, cidr)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		case r.Method == http.MethodPost && r.URL.Path == cidrsPath:
+			var cidr CapellaAllowedCIDR
+			if err := json.NewDecoder(r.Body).Decode(&cidr); err != nil {
+				t.Errorf("unable to decode the cidr: %s", err)
+			}
+			next++
+			cidr.ID = fmt.Sprintf("renewed-%d", next)
+			cidrs[cidr.ID] = cidr
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"id":%q}`, cidr.ID)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, cidrsPath+"/"):
+			delete(cidrs, strings.TrimPrefix(r.URL.Path, cidrsPath+"/"))
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/organizations/org/apikeys/"):
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/users"):
+			fmt.Fprint(w, `{"data":[{"id":"user-id","name":"V_CI"}],"cursor":{"pages":{"page":1}}}`)
+		case r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/users/user-id"):
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+}
+
+func TestLeaseCIDRs_RenewAndRevoke(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	cidrs := map[string]CapellaAllowedCIDR{
+		"lease":  {ID: "lease", Cidr: "203.0.113.7/32", Comment: leaseCIDRComment("V_CI"), ExpiresAt: expiresAt},
+		"office": {ID: "office", Cidr: "198.51.100.0/24", Comment: "office"},
+	}
+	srv := newLeaseCIDRTestServer(t, cidrs)
+	defer srv.Close()
+
+	db := &CouchbaseCapellaDB{couchbaseCapellaDBConnectionProducer: newTestConnectionProducer(srv.URL), logger: hclog.NewNullLogger()}
+	db.couchbaseCapellaDBConnectionProducer.logger = hclog.NewNullLogger()
+
+	// Without allowed_cidr in the renewal statement the entries are left alone.
+	renewed := time.Now().Add(24 * time.Hour)
+	_, err := db.UpdateUser(context.Background(), dbplugin.UpdateUserRequest{
+		Username:   "V_CI",
+		Expiration: &dbplugin.ChangeExpiration{NewExpiration: renewed},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cidrs["lease"].ExpiresAt != expiresAt || len(cidrs) != 2 {
+		t.Fatalf("expected the lease cidr to be kept, got %v", cidrs)
+	}
+
+	_, err = db.UpdateUser(context.Background(), dbplugin.UpdateUserRequest{
+		Username: "V_CI",
+		Expiration: &dbplugin.ChangeExpiration{
+			NewExpiration: renewed,
+			Statements:    dbplugin.Statements{Commands: []string{`{"allowed_cidr": "203.0.113.7"}`}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := cidrs["lease"]; ok || len(cidrs) != 2 {
+		t.Fatalf("expected the lease cidr to be replaced, got %v", cidrs)
+	}
+	for _, cidr := range cidrs {
+		if cidr.Comment == leaseCIDRComment("V_CI") && cidr.ExpiresAt != renewed.UTC().Format(time.RFC3339) {
+			t.Fatalf("expected the lease cidr to expire at %s, got %s", renewed, cidr.ExpiresAt)
+		}
+	}
+
+	// The revocation statements do not mention allowed_cidr.
+	_, err = db.DeleteUser(context.Background(), dbplugin.DeleteUserRequest{Username: "V_CI"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := cidrs["office"]; !ok || len(cidrs) != 1 {
+		t.Fatalf("expected only the lease cidr to be removed, got %v", cidrs)
+	}
+}
+
+func TestExtendLeaseCIDRs_KeepsEntryOnFailure(t *testing.T) {
+	const cidrsPath = "/organizations/org/projects/proj/clusters/cluster/allowedcidrs"
+	deleted := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == cidrsPath:
+			fmt.Fprintf(w, `{"data":[{"id":"lease","cidr":"203.0.113.7/32","comment":%q}]}`, leaseCIDRComment("V_CI"))
+		case r.Method == http.MethodPost && r.URL.Path == cidrsPath:
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.Method == http.MethodDelete:
+			deleted = true
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	cp := newTestConnectionProducer(srv.URL)
+	err := extendLeaseCIDRs(cp, []string{"cluster"}, "V_CI", time.Now().Add(time.Hour))
+	if err == nil {
+		t.Fatalf("expected the failed extension to be reported")
+	}
+	if deleted {
+		t.Fatalf("expected the previous entry to be kept when the new one cannot be created")
+	}
+}
@@ -0,0 +1,162 @@
+package couchbasecapella
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+)
+
+const (
+	permissionCheckWarn   = "warn"
+	permissionCheckStrict = "strict"
+	permissionCheckOff    = "off"
+
+	severityWarning = "warning"
+	severityError   = "error"
+)
+
+var (
+	// Roles that allow the management of database credentials on a project.
+	dbCredentialManagerRoles = []string{"projectOwner", "projectManager"}
+	// Roles that allow the rotation of an organization's API keys.
+	apiKeyRotationRoles = []string{"organizationOwner"}
+)
+
+// PermissionFinding is a single problem found while inspecting the root API key.
+type PermissionFinding struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// PermissionReport describes what the configured root API key is allowed to do.
+type PermissionReport struct {
+	APIKeyID          string   `json:"api_key_id"`
+	OrganizationRoles []string `json:"organization_roles"`
+	ProjectRoles      []string `json:"project_roles"`
+	// ClusterRoles are the roles the key has on configured clusters it is
+	// scoped to, by cluster ID.
+	ClusterRoles map[string][]string `json:"cluster_roles,omitempty"`
+	Findings     []PermissionFinding `json:"findings"`
+}
+
+func (r *PermissionReport) add(severity, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, PermissionFinding{Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// Errors returns the findings that prevent the plugin from working.
+func (r *PermissionReport) Errors() []PermissionFinding {
+	var errs []PermissionFinding
+	for _, f := range r.Findings {
+		if f.Severity == severityError {
+			errs = append(errs, f)
+		}
+	}
+	return errs
+}
+
+// checkPermissions inspects the roles and resources of the root API key and
+// reports whether it can manage database credentials on the configured project,
+// or on each configured cluster for a key scoped to clusters, and, when
+// expect_root_rotation is set, rotate its own secret. A self-managed
+// deployment has no API key, and its administrator credentials must never be
+// sent to the Capella API.
+func (c *couchbaseCapellaDBConnectionProducer) checkPermissions(ctx context.Context) (*PermissionReport, error) {
+	if c.Deployment == deploymentSelfManaged {
+		return nil, fmt.Errorf("permissions can only be checked for a %s deployment, as they are those of the root API key", deploymentCapella)
+	}
+	report := &PermissionReport{APIKeyID: c.Username}
+
+	key, err := GetCapellaAPIKey(c.CloudAPIBaseURL, c.OrganizationID, c.Username, c.Password, c.Username)
+	if err != nil {
+		var apiErr *CapellaAPIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden {
+			report.add(severityWarning, "the API key %s is not allowed to read its own roles, permissions could not be checked", c.Username)
+			return report, nil
+		}
+		return nil, fmt.Errorf("unable to inspect the API key %s: %w", c.Username, err)
+	}
+
+	report.OrganizationRoles = key.OrganizationRoles
+	for _, r := range key.Resources {
+		switch {
+		case r.Type == "project" && r.ID == c.ProjectID:
+			report.ProjectRoles = r.Roles
+		case r.Type == "cluster" && strutil.StrListContains(c.clusterIDs, r.ID):
+			if report.ClusterRoles == nil {
+				report.ClusterRoles = make(map[string][]string)
+			}
+			report.ClusterRoles[r.ID] = r.Roles
+		}
+	}
+
+	isOrgOwner := strutil.StrListContains(key.OrganizationRoles, "organizationOwner")
+	canManageProject := isOrgOwner || containsAny(report.ProjectRoles, dbCredentialManagerRoles)
+	if !canManageProject && len(report.ClusterRoles) > 0 {
+		// A key scoped to clusters must manage every configured cluster.
+		for _, clusterID := range c.clusterIDs {
+			roles := report.ClusterRoles[clusterID]
+			if !containsAny(roles, dbCredentialManagerRoles) {
+				report.add(severityError, "the API key %s cannot manage database credentials on cluster %s, it needs one of the roles %s on the project or the cluster (has %s)",
+					c.Username, clusterID, strings.Join(dbCredentialManagerRoles, ", "), strings.Join(append(append([]string{}, report.ProjectRoles...), roles...), ", "))
+			}
+		}
+	} else if !isOrgOwner && len(report.ProjectRoles) == 0 {
+		report.add(severityError, "the API key %s has no access to project %s", c.Username, c.ProjectID)
+	} else if !isOrgOwner && !containsAny(report.ProjectRoles, dbCredentialManagerRoles) {
+		report.add(severityError, "the API key %s cannot manage database credentials on project %s, it needs one of the project roles %s (has %s)",
+			c.Username, c.ProjectID, strings.Join(dbCredentialManagerRoles, ", "), strings.Join(report.ProjectRoles, ", "))
+	}
+
+	if !containsAny(key.OrganizationRoles, apiKeyRotationRoles) {
+		severity := severityWarning
+		if c.ExpectRootRotation {
+			severity = severityError
+		}
+		report.add(severity, "the API key %s cannot be rotated by the plugin, root rotation needs one of the organization roles %s",
+			c.Username, strings.Join(apiKeyRotationRoles, ", "))
+	}
+
+	return report, nil
+}
+
+// enforcePermissions runs checkPermissions according to permission_check,
+// logging every finding and failing on errors in strict mode.
+func (c *couchbaseCapellaDBConnectionProducer) enforcePermissions(ctx context.Context) error {
+	if c.PermissionCheck == permissionCheckOff {
+		return nil
+	}
+
+	report, err := c.checkPermissions(ctx)
+	if err != nil {
+		if c.PermissionCheck == permissionCheckStrict {
+			return err
+		}
+		c.logger.Warn("unable to check API key permissions", "error", err)
+		return nil
+	}
+
+	for _, f := range report.Findings {
+		c.logger.Warn("API key permission check", "severity", f.Severity, "message", f.Message)
+	}
+	if errs := report.Errors(); len(errs) > 0 && c.PermissionCheck == permissionCheckStrict {
+		msgs := make([]string, 0, len(errs))
+		for _, f := range errs {
+			msgs = append(msgs, f.Message)
+		}
+		return fmt.Errorf("API key permission check failed: %s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+func containsAny(list []string, items []string) bool {
+	for _, item := range items {
+		if strutil.StrListContains(list, item) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package couchbasecapella
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConnectionProducer_CheckPermissions(t *testing.T) {
+	tests := map[string]struct {
+		body               string
+		expectRootRotation bool
+		wantErrors         int
+		wantFindings       int
+	}{
+		"org owner": {
+			body:         `{"id":"ACCESS","organizationRoles":["organizationOwner"]}`,
+			wantErrors:   0,
+			wantFindings: 0,
+		},
+		"project manager": {
+			body:         `{"id":"ACCESS","organizationRoles":["organizationMember"],"resources":[{"id":"proj","type":"project","roles":["projectManager"]}]}`,
+			wantErrors:   0,
+			wantFindings: 1,
+		},
+		"project manager expecting rotation": {
+			body:               `{"id":"ACCESS","organizationRoles":["organizationMember"],"resources":[{"id":"proj","type":"project","roles":["projectManager"]}]}`,
+			expectRootRotation: true,
+			wantErrors:         1,
+			wantFindings:       1,
+		},
+		"read only on project": {
+			body:         `{"id":"ACCESS","organizationRoles":["organizationMember"],"resources":[{"id":"proj","type":"project","roles":["projectViewer"]}]}`,
+			wantErrors:   1,
+			wantFindings: 2,
+		},
+		"manager of the configured cluster": {
+			body:         `{"id":"ACCESS","organizationRoles":["organizationMember"],"resources":[{"id":"cluster","type":"cluster","roles":["projectManager"]}]}`,
+			wantErrors:   0,
+			wantFindings: 1,
+		},
+		"manager of another cluster": {
+			body:         `{"id":"ACCESS","organizationRoles":["organizationMember"],"resources":[{"id":"cluster","type":"cluster","roles":["projectViewer"]},{"id":"other","type":"cluster","roles":["projectManager"]}]}`,
+			wantErrors:   1,
+			wantFindings: 2,
+		},
+		"other project": {
+			body:         `{"id":"ACCESS","organizationRoles":["organizationMember"],"resources":[{"id":"other","type":"project","roles":["projectOwner"]}]}`,
+			wantErrors:   1,
+			wantFindings: 2,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/organizations/org/apikeys/ACCESS" {
+					t.Errorf("unexpected path %s", r.URL.Path)
+				}
+				w.Write([]byte(test.body))
+			}))
+			defer srv.Close()
+
+			cp := newTestConnectionProducer(srv.URL)
+			cp.ExpectRootRotation = test.expectRootRotation
+
+			report, err := cp.checkPermissions(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(report.Findings) != test.wantFindings {
+				t.Fatalf("expected %d findings, got %v", test.wantFindings, report.Findings)
+			}
+			if len(report.Errors()) != test.wantErrors {
+				t.Fatalf("expected %d errors, got %v", test.wantErrors, report.Errors())
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package couchbasecapella
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// propagationAttemptTimeout bounds each attempt of wait_for_propagation to
+// connect as the new user.
+var propagationAttemptTimeout = 5 * time.Second
+
+// propagationProbe connects to connStr as username once. The connection
+// waits for the cluster rather than bucket_name, which the new user may not
+// be allowed to open.
+var propagationProbe = func(ctx context.Context, c *couchbaseCapellaDBConnectionProducer, connStr, username, password string) error {
+	cluster, err := c.connectTo(ctx, connStr, "", username, password, propagationAttemptTimeout)
+	if err != nil {
+		return err
+	}
+	cluster.Close(nil)
+	return nil
+}
+
+// dataPlanes returns the data-plane connection strings of the clusters a new
+// user was created on. hosts or connect_url address the first configured
+// cluster, and a self-managed deployment; the connection string of any other
+// cluster is read from Capella.
+func (c *couchbaseCapellaDBConnectionProducer) dataPlanes(clusterIDs []string) ([]string, error) {
+	if len(clusterIDs) == 0 {
+		return []string{c.connectionString()}, nil
+	}
+
+	var connStrs []string
+	for _, clusterID := range clusterIDs {
+		if len(c.clusterIDs) > 0 && clusterID == c.clusterIDs[0] && c.connectionString() != "" {
+			connStrs = append(connStrs, c.connectionString())
+			continue
+		}
+		cluster, err := c.clusterState(c.clustersPath(clusterID), false)
+		if err != nil {
+			return nil, err
+		}
+		if cluster.ConnectionString == "" {
+			return nil, fmt.Errorf("cluster %s has no connection string", clusterID)
+		}
+		connStr := cluster.ConnectionString
+		if !strings.Contains(connStr, "://") {
+			connStr = "couchbases://" + connStr
+		}
+		connStrs = append(connStrs, connStr)
+	}
+	return connStrs, nil
+}
+
+// waitForPropagation connects to connStr as username until the credentials
+// are accepted or ctx is done. Capella takes a few seconds to push new
+// database credentials to the cluster nodes.
+func (c *couchbaseCapellaDBConnectionProducer) waitForPropagation(ctx context.Context, connStr, username, password string) error {
+	start := time.Now()
+	attempts := 0
+	check := func() error {
+		attempts++
+		return propagationProbe(ctx, c, connStr, username, password)
+	}
+
+	err := backoff.Retry(check, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
+	if err != nil {
+		return fmt.Errorf("user %s could not authenticate on %s after %d attempts: %w", username, connStr, attempts, err)
+	}
+	c.logger.Debug("credentials propagated", "user", username, "connection", connStr, "attempts", attempts, "elapsed", time.Since(start))
+	return nil
+}
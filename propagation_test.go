@@ -0,0 +1,111 @@
+package couchbasecapella
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// stubPropagationProbe replaces the data-plane connection of
+// wait_for_propagation for the duration of the test.
+func stubPropagationProbe(t *testing.T, probe func(connStr string) error) {
+	previous := propagationProbe
+	propagationProbe = func(_ context.Context, _ *couchbaseCapellaDBConnectionProducer, connStr, _, _ string) error {
+		return probe(connStr)
+	}
+	t.Cleanup(func() { propagationProbe = previous })
+}
+
+func TestWaitForPropagation(t *testing.T) {
+	errRejected := errors.New("authentication failure")
+	tests := map[string]struct {
+		failures int
+		timeout  time.Duration
+		cancel   bool
+		wantErr  bool
+	}{
+		"accepted":          {},
+		"accepted on retry": {failures: 1},
+		"never accepted":    {failures: -1, timeout: 300 * time.Millisecond, wantErr: true},
+		"cancelled":         {failures: -1, cancel: true, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			attempts := 0
+			stubPropagationProbe(t, func(string) error {
+				attempts++
+				if test.failures < 0 || attempts <= test.failures {
+					return errRejected
+				}
+				return nil
+			})
+
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+			if test.cancel {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(ctx)
+				cancel()
+			}
+
+			cp := newTestConnectionProducer("")
+			cp.logger = hclog.NewNullLogger()
+			start := time.Now()
+			err := cp.waitForPropagation(ctx, "couchbases://cb.example.com", "V_USER", "PASSWORD")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if test.wantErr && !strings.Contains(err.Error(), "could not authenticate on couchbases://cb.example.com") {
+				t.Fatalf("expected the error to name the connection, got %q", err)
+			}
+			if !test.wantErr && attempts != test.failures+1 {
+				t.Fatalf("expected %d attempts, got %d", test.failures+1, attempts)
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("expected the wait to end with the context, took %s", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestVerifyNewUser_ProbesEveryCluster(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/organizations/org/projects/proj/clusters/other" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"id":"other","currentState":"healthy","connectionString":"cb.other.example.com"}`))
+	}))
+	defer srv.Close()
+
+	var probed []string
+	stubPropagationProbe(t, func(connStr string) error {
+		probed = append(probed, connStr)
+		return nil
+	})
+
+	cp := newTestConnectionProducer(srv.URL)
+	cp.logger = hclog.NewNullLogger()
+	cp.Hosts = "couchbases://cb.cluster.example.com"
+	cp.WaitForPropagation = true
+	cp.clusterIDs = []string{"cluster", "other"}
+
+	err := cp.verifyNewUser(context.Background(), []string{"cluster", "other"}, &capellaStatement{}, "V_USER", "PASSWORD")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := "couchbases://cb.cluster.example.com,couchbases://cb.other.example.com"
+	if strings.Join(probed, ",") != want {
+		t.Fatalf("expected %s to be probed, got %v", want, probed)
+	}
+}
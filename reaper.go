@@ -0,0 +1,326 @@
+package couchbasecapella
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+	"github.com/hashicorp/vault/sdk/database/helper/connutil"
+	"github.com/hashicorp/vault/sdk/helper/template"
+)
+
+const defaultReapMaxDeletions = 100
+
+// ReapOptions configures ReapOrphanedUsers.
+type ReapOptions struct {
+	// MaxAge is the age after which a user is considered orphaned. It must
+	// exceed the max_ttl of every role of the database configuration.
+	MaxAge time.Duration
+
+	// DryRun only reports the users that would be deleted.
+	DryRun bool
+
+	// MaxDeletions caps the number of users deleted in one run, 100 if not set.
+	MaxDeletions int
+}
+
+// ReapedUser is an orphaned user found by ReapOrphanedUsers.
+type ReapedUser struct {
+	ClusterID string    `json:"cluster_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	Deleted   bool      `json:"deleted"`
+	Error     string    `json:"error,omitempty"`
+
+	id string
+}
+
+// ReapReport is the result of ReapOrphanedUsers.
+type ReapReport struct {
+	// Shape is the regular expression derived from the username template
+	// that every reaped username matches.
+	Shape  string `json:"shape"`
+	DryRun bool   `json:"dry_run"`
+
+	// Orphans are the users older than MaxAge, oldest first.
+	Orphans []ReapedUser `json:"orphans"`
+
+	// Remaining is the number of orphans left for a later run because of
+	// MaxDeletions.
+	Remaining int `json:"remaining"`
+}
+
+// ReapOrphanedUsers deletes the database credentials that were issued by the
+// plugin but outlived their lease, for example because Vault lost the lease
+// in a storage restore. Users are recognized by the shape of the usernames
+// the username template generates, and their age by the unix time the
+// template embeds; other users are never touched.
+func (c *CouchbaseCapellaDB) ReapOrphanedUsers(ctx context.Context, opts ReapOptions) (*ReapReport, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if !c.Initialized {
+		return nil, connutil.ErrNotInitialized
+	}
+	if c.Deployment == deploymentSelfManaged {
+		return nil, fmt.Errorf("reaping orphaned users is not supported by a %s deployment", deploymentSelfManaged)
+	}
+	if opts.MaxAge <= 0 {
+		return nil, fmt.Errorf("the maximum age of users must be positive")
+	}
+	if opts.MaxDeletions <= 0 {
+		opts.MaxDeletions = defaultReapMaxDeletions
+	}
+	err := c.checkKeyExpiry()
+	if err != nil {
+		return nil, err
+	}
+
+	shape, err := c.usernameShape()
+	if err != nil {
+		return nil, err
+	}
+	if !shape.dated {
+		return nil, fmt.Errorf("username_template must embed unix_time or unix_time_millis exactly once, untruncated, for orphaned users to be found")
+	}
+	clusterIDs, err := c.managedClusters()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReapReport{Shape: shape.String(), DryRun: opts.DryRun}
+	cutoff := time.Now().Add(-opts.MaxAge)
+	for _, clusterID := range clusterIDs {
+		users, err := ListCapellaDbCredUsers(c.CloudAPIBaseURL, c.clustersPath(clusterID), c.Username, c.Password)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the users of cluster %s: %w", clusterID, err)
+		}
+		for _, user := range users {
+			createdAt, ok := shape.createdAt(user.Name)
+			if !ok || createdAt.After(cutoff) {
+				continue
+			}
+			report.Orphans = append(report.Orphans, ReapedUser{ClusterID: clusterID, Username: user.Name, CreatedAt: createdAt, id: user.ID})
+		}
+	}
+	sort.SliceStable(report.Orphans, func(i, j int) bool {
+		return report.Orphans[i].CreatedAt.Before(report.Orphans[j].CreatedAt)
+	})
+
+	if opts.DryRun {
+		return report, nil
+	}
+	deleted := 0
+	for i := range report.Orphans {
+		orphan := &report.Orphans[i]
+		if deleted >= opts.MaxDeletions || ctx.Err() != nil {
+			report.Remaining++
+			continue
+		}
+		err := DeleteCapellaDbCredUserByID(c.CloudAPIBaseURL, c.clustersPath(orphan.ClusterID), c.Username, c.Password, orphan.id)
+		if err != nil {
+			orphan.Error = err.Error()
+			c.logger.Error("failed to delete orphaned user", "user", orphan.Username, "cluster", orphan.ClusterID, "error", err)
+			continue
+		}
+		orphan.Deleted = true
+		deleted++
+		c.logger.Info("deleted orphaned user", "user", orphan.Username, "cluster", orphan.ClusterID, "created_at", orphan.CreatedAt)
+	}
+	return report, nil
+}
+
+// usernameShapeFunctions are the two sets of values usernameShape renders
+// the functions that make a username unique with. Each function returns a
+// run of its own digit, different in each set and unchanged by the username
+// rules, so that comparing two renderings position by position tells which
+// part of a username came from which function.
+var usernameShapeFunctions = [2]struct {
+	random, unixTime, unixTimeMillis, uuid byte
+}{
+	{random: '0', unixTime: '1', unixTimeMillis: '2', uuid: '3'},
+	{random: '4', unixTime: '5', unixTimeMillis: '6', uuid: '7'},
+}
+
+// usernameShapeNames are the letters usernameShape fills the display name,
+// role name, cluster and project names with. They are not hex digits, so a
+// hash of the names is not mistaken for the names themselves.
+const usernameShapeNames = "wxyz"
+
+// usernameShape matches the usernames the template generates. If dated is
+// set, its only submatch is the unix time, in seconds or milliseconds, the
+// username was created at.
+type usernameShape struct {
+	*regexp.Regexp
+	millis bool
+	dated  bool
+}
+
+// renderUsernameShape renders the username template with the values of the
+// function set functions and every name made of the letter name.
+func (c *CouchbaseCapellaDB) renderUsernameShape(functions int, name byte) (string, error) {
+	f := usernameShapeFunctions[functions]
+	names := strings.Repeat(string(name), 4)
+	opts := append([]template.Opt{template.Template(c.usernameTemplate)}, c.usernameTemplateFunctions(true)...)
+	opts = append(opts,
+		template.Function("random", func(n int) (string, error) { return strings.Repeat(string(f.random), n), nil }),
+		template.Function("unix_time", func() string { return strings.Repeat(string(f.unixTime), 10) }),
+		template.Function("unix_time_millis", func() string { return strings.Repeat(string(f.unixTimeMillis), 13) }),
+		template.Function("uuid", func() (string, error) { return strings.Repeat(string(f.uuid), 36), nil }),
+		template.Function("cluster_name", func() (string, error) { return names, nil }),
+		template.Function("project_name", func() (string, error) { return names, nil }),
+	)
+	producer, err := template.NewTemplate(opts...)
+	if err != nil {
+		return "", fmt.Errorf("unable to initialize username template: %w", err)
+	}
+	return c.renderUsername(producer, dbplugin.UsernameMetadata{DisplayName: names, RoleName: names})
+}
+
+// usernameShape derives the shape of the usernames from the username
+// template. Parts that do not vary with the request or over time must match
+// exactly, random and uuid parts must have their length, parts derived from
+// the names, such as a hash, must have their length, and the names may be
+// anything a username may contain. The shape is dated if the template embeds
+// unix_time or unix_time_millis exactly once, untruncated.
+func (c *CouchbaseCapellaDB) usernameShape() (*usernameShape, error) {
+	// base and functions differ only in the functions, base and each of
+	// names only in the names.
+	base, err := c.renderUsernameShape(0, usernameShapeNames[0])
+	if err != nil {
+		return nil, err
+	}
+	functions, err := c.renderUsernameShape(1, usernameShapeNames[0])
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for i := 1; i < len(usernameShapeNames); i++ {
+		rendering, err := c.renderUsernameShape(0, usernameShapeNames[i])
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, rendering)
+	}
+	for _, rendering := range append(names, functions) {
+		if len(rendering) != len(base) {
+			return nil, fmt.Errorf("the shape of the usernames cannot be derived from username_template")
+		}
+	}
+
+	const (
+		partLiteral = iota
+		partRandom
+		partUnixTime
+		partUnixTimeMillis
+		partUUID
+		partName
+		partOther
+	)
+	classify := func(i int) int {
+		a, b := base[i], functions[i]
+		if a != b {
+			switch {
+			case a == usernameShapeFunctions[0].random && b == usernameShapeFunctions[1].random:
+				return partRandom
+			case a == usernameShapeFunctions[0].unixTime && b == usernameShapeFunctions[1].unixTime:
+				return partUnixTime
+			case a == usernameShapeFunctions[0].unixTimeMillis && b == usernameShapeFunctions[1].unixTimeMillis:
+				return partUnixTimeMillis
+			case a == usernameShapeFunctions[0].uuid && b == usernameShapeFunctions[1].uuid:
+				return partUUID
+			default:
+				return partOther
+			}
+		}
+		literal, name := true, lower(a) == usernameShapeNames[0]
+		for k, rendering := range names {
+			literal = literal && rendering[i] == a
+			name = name && lower(rendering[i]) == usernameShapeNames[k+1]
+		}
+		switch {
+		case literal:
+			return partLiteral
+		case name:
+			return partName
+		default:
+			// Derived from the names, such as a hash of the display name.
+			return partOther
+		}
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	times := 0
+	millis, truncated := false, false
+	for i := 0; i < len(base); {
+		part := classify(i)
+		j := i + 1
+		for j < len(base) && classify(j) == part {
+			j++
+		}
+		n := j - i
+		switch part {
+		case partLiteral:
+			pattern.WriteString(regexp.QuoteMeta(base[i:j]))
+		case partRandom:
+			fmt.Fprintf(&pattern, "[0-9A-Za-z]{%d}", n)
+		case partUnixTime, partUnixTimeMillis:
+			width := 10
+			if part == partUnixTimeMillis {
+				width = 13
+				millis = true
+			}
+			if n != width {
+				fmt.Fprintf(&pattern, "[0-9]{%d}", n)
+				truncated = true
+				break
+			}
+			fmt.Fprintf(&pattern, "([0-9]{%d})", n)
+			times++
+		case partUUID:
+			fmt.Fprintf(&pattern, "[0-9A-Fa-f-]{%d}", n)
+		case partName:
+			pattern.WriteString("[0-9A-Za-z._-]*")
+		case partOther:
+			fmt.Fprintf(&pattern, "[0-9A-Za-z._-]{%d}", n)
+		}
+		i = j
+	}
+	pattern.WriteString("$")
+	return &usernameShape{Regexp: regexp.MustCompile(pattern.String()), millis: millis, dated: times == 1 && !truncated}, nil
+}
+
+// lower returns the lower case of an ASCII letter.
+func lower(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// createdAt returns the creation time embedded in username if it has the
+// shape and the time is not in the future.
+func (s *usernameShape) createdAt(username string) (time.Time, bool) {
+	match := s.FindStringSubmatch(username)
+	if match == nil {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t := time.Unix(n, 0)
+	if s.millis {
+		t = time.UnixMilli(n)
+	}
+	if t.After(time.Now().Add(time.Hour)) {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,167 @@
+package couchbasecapella
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/helper/template"
+)
+
+func TestCouchbaseCapellaDB_ReapOrphanedUsers(t *testing.T) {
+	old := time.Now().Add(-72 * time.Hour).Unix()
+	older := time.Now().Add(-96 * time.Hour).Unix()
+	users := fmt.Sprintf(`{"data":[
+		{"id":"1","name":"V_TOKEN_ROLE_ABCDEFGHIJ0123456789_%d"},
+		{"id":"2","name":"V_TOKEN_ROLE_DEFGHIJKLM0123456789_%d"},
+		{"id":"3","name":"V_TOKEN_ROLE_GHIJKLMNOP0123456789_%d"},
+		{"id":"4","name":"APP_USER_%d"},
+		{"id":"5","name":"V_STATIC_USER"},
+		{"id":"6","name":"V_BACKUP_%d"}],"cursor":{"pages":{"page":1}}}`,
+		old, older, time.Now().Unix(), older, older)
+
+	tests := map[string]struct {
+		opts          ReapOptions
+		wantOrphans   []string
+		wantDeleted   []string
+		wantRemaining int
+	}{
+		"dry run": {
+			opts:        ReapOptions{MaxAge: 24 * time.Hour, DryRun: true},
+			wantOrphans: []string{"2", "1"},
+		},
+		"delete": {
+			opts:        ReapOptions{MaxAge: 24 * time.Hour},
+			wantOrphans: []string{"2", "1"},
+			wantDeleted: []string{"2", "1"},
+		},
+		"capped": {
+			opts:          ReapOptions{MaxAge: 24 * time.Hour, MaxDeletions: 1},
+			wantOrphans:   []string{"2", "1"},
+			wantDeleted:   []string{"2"},
+			wantRemaining: 1,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var deleted []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/organizations/org/projects/proj/clusters/cluster/users":
+					fmt.Fprint(w, users)
+				case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/organizations/org/projects/proj/clusters/cluster/users/"):
+					deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/organizations/org/projects/proj/clusters/cluster/users/"))
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer srv.Close()
+
+			up, err := template.NewTemplate(template.Template(defaultUserNameTemplate))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cp := newTestConnectionProducer(srv.URL)
+			cp.Initialized = true
+			cp.logger = hclog.NewNullLogger()
+			db := &CouchbaseCapellaDB{couchbaseCapellaDBConnectionProducer: cp, usernameTemplate: defaultUserNameTemplate, usernameProducer: up, logger: hclog.NewNullLogger()}
+
+			report, err := db.ReapOrphanedUsers(context.Background(), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var orphans []string
+			for _, orphan := range report.Orphans {
+				orphans = append(orphans, orphan.Username)
+			}
+			if len(orphans) != len(test.wantOrphans) {
+				t.Fatalf("unexpected orphans %v", orphans)
+			}
+			if fmt.Sprint(deleted) != fmt.Sprint(test.wantDeleted) {
+				t.Fatalf("expected deleted %v, got %v", test.wantDeleted, deleted)
+			}
+			if report.Remaining != test.wantRemaining {
+				t.Fatalf("expected %d remaining, got %d", test.wantRemaining, report.Remaining)
+			}
+		})
+	}
+}
+
+func TestCouchbaseCapellaDB_UsernameShape(t *testing.T) {
+	now := time.Now()
+	tests := map[string]struct {
+		template    string
+		username    string
+		wantMatch   bool
+		wantCreated int64
+		wantUndated bool
+	}{
+		"default": {
+			template:    defaultUserNameTemplate,
+			username:    fmt.Sprintf("V_OIDC-JANE_READONLY_ABCDEFGHIJ0123456789_%d", now.Unix()),
+			wantMatch:   true,
+			wantCreated: now.Unix(),
+		},
+		"default with short random": {
+			template: defaultUserNameTemplate,
+			username: fmt.Sprintf("V_TOKEN_READONLY_ABC_%d", now.Unix()),
+		},
+		"default other prefix": {
+			template: defaultUserNameTemplate,
+			username: fmt.Sprintf("X_TOKEN_READONLY_ABCDEFGHIJ0123456789_%d", now.Unix()),
+		},
+		"default in the future": {
+			template: defaultUserNameTemplate,
+			username: fmt.Sprintf("V_TOKEN_READONLY_ABCDEFGHIJ0123456789_%d", now.Add(48*time.Hour).Unix()),
+		},
+		"millis and hash": {
+			template:    `{{printf "app-%s-%s" (short_hash .DisplayName .RoleName) (unix_time_millis)}}`,
+			username:    fmt.Sprintf("APP-0123ABCD-%d", now.UnixMilli()),
+			wantMatch:   true,
+			wantCreated: now.Unix(),
+		},
+		"no time": {
+			template:    `{{printf "V_%s_%s" .RoleName (random 20)}}`,
+			wantUndated: true,
+		},
+		"two times": {
+			template:    `{{printf "V_%s_%s_%s" .RoleName (unix_time) (unix_time)}}`,
+			wantUndated: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := &CouchbaseCapellaDB{
+				couchbaseCapellaDBConnectionProducer: newTestConnectionProducer(""),
+				usernameTemplate:                     test.template,
+				usernameRules:                        usernameRules{caseMode: usernameCaseUpper, replacement: defaultUsernameReplacement, collapse: true},
+				logger:                               hclog.NewNullLogger(),
+			}
+			shape, err := db.usernameShape()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if shape.dated == test.wantUndated {
+				t.Fatalf("expected dated to be %t", !test.wantUndated)
+			}
+			if test.wantUndated {
+				return
+			}
+			createdAt, ok := shape.createdAt(test.username)
+			if ok != test.wantMatch {
+				t.Fatalf("expected %q to match %s: %t", test.username, shape, test.wantMatch)
+			}
+			if ok && createdAt.Unix() != test.wantCreated {
+				t.Fatalf("expected created at %d, got %d", test.wantCreated, createdAt.Unix())
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package couchbasecapella
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+const (
+	rootRotationRotate  = "rotate"
+	rootRotationReplace = "replace"
+)
+
+// rootSecretVerifyTimeout bounds how long a rotated secret may take to be
+// accepted by Capella.
+var rootSecretVerifyTimeout = 30 * time.Second
+
+// rootUsername returns the access key of the root API key.
+func (c *couchbaseCapellaDBConnectionProducer) rootUsername() string {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.Username
+}
+
+// rotateRootCredentials rotates the secret of the root API key in two phases:
+// the secret is rotated, then the new secret is used to authenticate against
+// Capella. Success is only reported, and the new secret only used by the
+// plugin, once Capella accepts it.
+func (c *couchbaseCapellaDBConnectionProducer) rotateRootCredentials(ctx context.Context, newSecret string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.Deployment == deploymentSelfManaged {
+		return c.rotateSelfManagedRoot(newSecret)
+	}
+
+	err := c.checkKeyExpiry()
+	if err != nil {
+		return err
+	}
+
+	returned, err := RotateCapellaAPIKey(c.CloudAPIBaseURL, c.OrganizationID, c.Username, c.Password, c.Username, newSecret)
+	if err != nil {
+		return fmt.Errorf("failed to rotate the root API key %s: %w", c.Username, err)
+	}
+
+	if returned != "" && returned != newSecret {
+		// Vault stores the requested secret, so it is the one the key must
+		// end up with. Try once to set it using the secret Capella returned.
+		c.logger.Warn("capella returned a different secret than requested for the root API key, rotating again", "key", c.Username)
+		again, err := RotateCapellaAPIKey(c.CloudAPIBaseURL, c.OrganizationID, c.Username, returned, c.Username, newSecret)
+		if err != nil || (again != "" && again != newSecret) {
+			return fmt.Errorf("capella set the secret of the root API key %s to a different value than requested and it could not be corrected, "+
+				"the key must be fixed manually before Vault can use it: %v", c.Username, err)
+		}
+	}
+
+	err = c.verifyRootSecret(ctx, c.Username, newSecret)
+	if err != nil {
+		oldErr := GetCapellaOrganization(c.CloudAPIBaseURL, c.OrganizationID, c.Username, c.Password)
+		if oldErr == nil {
+			return fmt.Errorf("the rotated secret of the root API key %s was not accepted by Capella and the previous secret is still valid, the rotation was not applied: %w", c.Username, err)
+		}
+		return fmt.Errorf("the rotated secret of the root API key %s was not accepted by Capella and the previous secret no longer works, "+
+			"the key must be fixed manually before Vault can use it: %w", c.Username, err)
+	}
+
+	c.Password = newSecret
+	if c.rawConfig != nil {
+		c.rawConfig["password"] = newSecret
+	}
+	c.logger.Info("rotated and verified the root API key secret", "key", c.Username)
+	c.refreshKeyExpiry(c.Username, c.Password)
+	return nil
+}
+
+// verifyRootSecret authenticates as the API key accessKey with secret against
+// a read only endpoint, retrying while the new secret propagates.
+func (c *couchbaseCapellaDBConnectionProducer) verifyRootSecret(ctx context.Context, accessKey string, secret string) error {
+	ctx, cancel := context.WithTimeout(ctx, rootSecretVerifyTimeout)
+	defer cancel()
+
+	check := func() error {
+		err := GetCapellaOrganization(c.CloudAPIBaseURL, c.OrganizationID, accessKey, secret)
+		var apiErr *CapellaAPIError
+		if err != nil && errors.As(err, &apiErr) && apiErr.StatusCode != http.StatusUnauthorized {
+			// Anything but a rejected key will not improve with time.
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	return backoff.Retry(check, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
+}
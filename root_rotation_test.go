@@ -0,0 +1,78 @@
+package couchbasecapella
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestConnectionProducer_RotateRootCredentials(t *testing.T) {
+	timeout := rootSecretVerifyTimeout
+	t.Cleanup(func() { rootSecretVerifyTimeout = timeout })
+	rootSecretVerifyTimeout = 100 * time.Millisecond
+
+	tests := map[string]struct {
+		acceptNewSecret bool
+		wantPassword    string
+		wantErr         bool
+	}{
+		"accepted": {acceptNewSecret: true, wantPassword: "NEW"},
+		"rejected": {acceptNewSecret: false, wantPassword: "SECRET", wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPost && r.URL.Path == "/organizations/org/apikeys/ACCESS/rotate":
+					fmt.Fprint(w, `{"secretKey":"NEW"}`)
+				case r.Method == http.MethodGet && r.URL.Path == "/organizations/org/apikeys/ACCESS":
+					fmt.Fprint(w, `{"id":"ACCESS"}`)
+				case r.Method == http.MethodGet && r.URL.Path == "/organizations/org":
+					newAuth := "Bearer " + base64.StdEncoding.EncodeToString([]byte("ACCESS:NEW"))
+					if r.Header.Get("Authorization") == newAuth && !test.acceptNewSecret {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					fmt.Fprint(w, `{}`)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer srv.Close()
+
+			cp := newTestConnectionProducer(srv.URL)
+			cp.logger = hclog.NewNullLogger()
+
+			err := cp.rotateRootCredentials(context.Background(), "NEW")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if cp.Password != test.wantPassword {
+				t.Fatalf("expected password %q, got %q", test.wantPassword, cp.Password)
+			}
+		})
+	}
+}
+
+func TestConnectionProducer_InitRejectsReplaceMode(t *testing.T) {
+	cp := &couchbaseCapellaDBConnectionProducer{}
+	_, err := cp.Init(context.Background(), map[string]interface{}{
+		"username":           "ACCESS",
+		"password":           "SECRET",
+		"organization_id":    "org",
+		"project_id":         "proj",
+		"cluster_id":         "cluster",
+		"root_rotation_mode": rootRotationReplace,
+	}, false)
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("expected root_rotation_mode replace to be rejected, got %v", err)
+	}
+}
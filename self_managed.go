@@ -0,0 +1,211 @@
+package couchbasecapella
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+)
+
+const (
+	deploymentCapella     = "capella"
+	deploymentSelfManaged = "self_managed"
+
+	defaultSelfManagedRole = "data_reader[*]"
+)
+
+// selfManagedRESTPort is the TLS port of the Couchbase Server REST API.
+var selfManagedRESTPort = 18091
+
+// restHost returns the first host of a couchbase:// or couchbases://
+// connection string, without port and options.
+func restHost(hosts string) (string, error) {
+	if i := strings.Index(hosts, "://"); i >= 0 {
+		hosts = hosts[i+3:]
+	}
+	if i := strings.IndexAny(hosts, "?/"); i >= 0 {
+		hosts = hosts[:i]
+	}
+	host := strings.TrimSpace(strings.Split(hosts, ",")[0])
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == "" {
+		return "", fmt.Errorf("no host found in hosts %q", hosts)
+	}
+	return host, nil
+}
+
+// restClient returns a client for the REST API of the self-managed cluster,
+// authenticated with the configured administrator.
+func (c *couchbaseCapellaDBConnectionProducer) restClient() (*CouchbaseRESTClient, error) {
+	return c.restClientAs(c.Username, c.Password)
+}
+
+func (c *couchbaseCapellaDBConnectionProducer) restClientAs(username, password string) (*CouchbaseRESTClient, error) {
+	host, err := restHost(c.Hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureTLS}
+	if len(c.Base64Pem) > 0 {
+		ca, err := c.clusterCA()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("failed to parse root certificate")
+		}
+	}
+
+	baseURL := "https://" + net.JoinHostPort(host, strconv.Itoa(selfManagedRESTPort))
+	return NewCouchbaseRESTClient(baseURL, username, password, tlsConfig), nil
+}
+
+// verifySelfManaged checks that the REST API of the self-managed cluster is
+// reachable and accepts the configured administrator.
+func (c *couchbaseCapellaDBConnectionProducer) verifySelfManaged() error {
+	client, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	err = client.WhoAmI()
+	var restErr *CouchbaseRESTError
+	if errors.As(err, &restErr) && restErr.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("the credentials of %s were rejected by Couchbase Server, check username and password", c.Username)
+	}
+	return err
+}
+
+// newSelfManagedUser creates username as a local user of the self-managed
+// cluster with the roles of the statement.
+func newSelfManagedUser(ctx context.Context, c *couchbaseCapellaDBConnectionProducer, username string, stmt *capellaStatement, req dbplugin.NewUserRequest) error {
+	roles := stmt.Roles
+	if len(roles) == 0 && len(stmt.Groups) == 0 {
+		roles = []string{defaultSelfManagedRole}
+	}
+
+	client, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	if rolesUseCollections(roles) {
+		v, err := selfManagedVersion(client)
+		if err != nil {
+			return err
+		}
+		err = checkCollectionsSupported(v, "the cluster")
+		if err != nil {
+			return err
+		}
+	}
+	err = checkGroupsExist(client, stmt.Groups)
+	if err != nil {
+		return err
+	}
+	err = client.UpsertRBACUser(username, req.Password, roles, stmt.Groups)
+	if err != nil {
+		return fmt.Errorf("failed to create user %s: %w", username, err)
+	}
+
+	err = c.verifyNewUser(ctx, nil, stmt, username, req.Password)
+	if err != nil {
+		if delErr := client.DeleteRBACUser(username); delErr != nil {
+			c.logger.Error("failed to roll back user creation", "user", username, "error", delErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkGroupsExist fails unless every group exists, so a role cannot put
+// users in a group nobody defined.
+func checkGroupsExist(client *CouchbaseRESTClient, groups []string) error {
+	for _, group := range groups {
+		_, err := client.GetRBACGroup(group)
+		var restErr *CouchbaseRESTError
+		if errors.As(err, &restErr) && restErr.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("group %q does not exist, groups must be created on the cluster before roles can use them", group)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read group %q: %w", group, err)
+		}
+	}
+	return nil
+}
+
+// updateSelfManagedUserPassword sets the password of username. The REST API
+// replaces the whole user, so its roles and groups are read first and
+// written back unchanged.
+func updateSelfManagedUserPassword(c *couchbaseCapellaDBConnectionProducer, username, password string) error {
+	client, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	user, err := client.GetRBACUser(username)
+	if err != nil {
+		return fmt.Errorf("failed to read user %s: %w", username, err)
+	}
+	err = client.UpsertRBACUser(username, password, user.DirectRoles(), user.Groups)
+	if err != nil {
+		return fmt.Errorf("failed to update user %s: %w", username, err)
+	}
+	return nil
+}
+
+// deleteSelfManagedUser deletes username from the self-managed cluster. A
+// user that no longer exists is not an error.
+func deleteSelfManagedUser(c *couchbaseCapellaDBConnectionProducer, username string) error {
+	client, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	err = client.DeleteRBACUser(username)
+	var restErr *CouchbaseRESTError
+	if errors.As(err, &restErr) && restErr.StatusCode == http.StatusNotFound {
+		c.logger.Warn("user to revoke was not found", "user", username)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete user %s: %w", username, err)
+	}
+	return nil
+}
+
+// rotateSelfManagedRoot changes the password of the administrator and
+// checks that the new password is accepted before using it.
+func (c *couchbaseCapellaDBConnectionProducer) rotateSelfManagedRoot(newPassword string) error {
+	client, err := c.restClient()
+	if err != nil {
+		return err
+	}
+	err = client.ChangePassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to change the password of %s: %w", c.Username, err)
+	}
+
+	client, err = c.restClientAs(c.Username, newPassword)
+	if err != nil {
+		return err
+	}
+	if err := client.WhoAmI(); err != nil {
+		return fmt.Errorf("the new password of %s is not accepted: %w", c.Username, err)
+	}
+
+	c.Password = newPassword
+	if c.rawConfig != nil {
+		c.rawConfig["password"] = newPassword
+	}
+	c.logger.Info("changed and verified the administrator password", "user", c.Username)
+	return nil
+}
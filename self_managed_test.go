@@ -0,0 +1,158 @@
+package couchbasecapella
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+)
+
+func TestRestHost(t *testing.T) {
+	tests := map[string]string{
+		"couchbases://cb1.example.com":                   "cb1.example.com",
+		"couchbases://cb1.example.com,cb2.example.com":   "cb1.example.com",
+		"couchbase://cb1.example.com:11210?network=auto": "cb1.example.com",
+		"cb1.example.com":                                "cb1.example.com",
+		"couchbases://[::1]:11207":                       "::1",
+	}
+	for hosts, want := range tests {
+		got, err := restHost(hosts)
+		if err != nil {
+			t.Fatalf("%s: %v", hosts, err)
+		}
+		if got != want {
+			t.Fatalf("%s: expected %q, got %q", hosts, want, got)
+		}
+	}
+	if _, err := restHost("couchbases://"); err == nil {
+		t.Fatal("expected an error for a connection string without host")
+	}
+}
+
+func newTestSelfManagedProducer(t *testing.T, srv *httptest.Server) *couchbaseCapellaDBConnectionProducer {
+	u, _ := url.Parse(srv.URL)
+	port := selfManagedRESTPort
+	t.Cleanup(func() { selfManagedRESTPort = port })
+	selfManagedRESTPort, _ = strconv.Atoi(u.Port())
+	return &couchbaseCapellaDBConnectionProducer{
+		Username:    "Administrator",
+		Password:    "password",
+		Deployment:  deploymentSelfManaged,
+		Hosts:       "couchbases://" + u.Hostname(),
+		InsecureTLS: true,
+		logger:      hclog.NewNullLogger(),
+	}
+}
+
+func TestNewSelfManagedUser_Groups(t *testing.T) {
+	tests := map[string]struct {
+		statement  string
+		wantRoles  string
+		wantGroups string
+		wantErr    bool
+	}{
+		"groups only":     {statement: `{"groups": ["analytics-readers"]}`, wantGroups: "analytics-readers"},
+		"roles and group": {statement: `{"roles": ["data_reader[orders]"], "groups": ["analytics-readers"]}`, wantRoles: "data_reader[orders]", wantGroups: "analytics-readers"},
+		"default role":    {statement: `{}`, wantRoles: "data_reader[*]"},
+		"missing group":   {statement: `{"groups": ["analytics-readers", "unknown"]}`, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var put url.Values
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/settings/rbac/groups/analytics-readers":
+					w.Write([]byte(`{"id":"analytics-readers","roles":[{"role":"analytics_reader"}]}`))
+				case r.Method == http.MethodGet && r.URL.Path == "/settings/rbac/groups/unknown":
+					w.WriteHeader(http.StatusNotFound)
+				case r.Method == http.MethodPut && r.URL.Path == "/settings/rbac/users/local/V_APP":
+					r.ParseForm()
+					put = r.PostForm
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer srv.Close()
+
+			stmt, err := parseStatement(test.statement)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := dbplugin.NewUserRequest{Password: "PASSWORD"}
+			err = newSelfManagedUser(context.Background(), newTestSelfManagedProducer(t, srv), "V_APP", stmt, req)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if test.wantErr {
+				if put != nil {
+					t.Fatal("the user was created despite the missing group")
+				}
+				return
+			}
+			if put.Get("roles") != test.wantRoles || put.Get("groups") != test.wantGroups {
+				t.Fatalf("unexpected roles %q and groups %q", put.Get("roles"), put.Get("groups"))
+			}
+		})
+	}
+}
+
+func TestUpdateSelfManagedUserPassword(t *testing.T) {
+	var put url.Values
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "Administrator" || pass != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/settings/rbac/users/local/V_APP":
+			w.Write([]byte(`{"id":"V_APP","groups":["readers"],"roles":[
+				{"role":"data_reader","bucket_name":"orders","scope_name":"eu","collection_name":"*","origins":[{"type":"user"}]},
+				{"role":"query_select","bucket_name":"*","origins":[{"type":"group","name":"readers"}]},
+				{"role":"ro_admin"}]}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/settings/rbac/users/local/V_APP":
+			r.ParseForm()
+			put = r.PostForm
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	cp := newTestSelfManagedProducer(t, srv)
+	err := updateSelfManagedUserPassword(cp, "V_APP", "NEW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := put.Get("password"); got != "NEW" {
+		t.Fatalf("expected password NEW, got %q", got)
+	}
+	if got := put.Get("roles"); got != "data_reader[orders:eu:*],ro_admin" {
+		t.Fatalf("unexpected roles %q", got)
+	}
+	if got := put.Get("groups"); got != "readers" {
+		t.Fatalf("unexpected groups %q", got)
+	}
+}
+
+func TestDiagnose_SelfManaged(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	cp := newTestSelfManagedProducer(t, srv)
+	cp.CloudAPIBaseURL = srv.URL
+	cp.Initialized = true
+	db := &CouchbaseCapellaDB{couchbaseCapellaDBConnectionProducer: cp, logger: hclog.NewNullLogger()}
+
+	_, err := db.Diagnose(context.Background())
+	if err == nil {
+		t.Fatalf("expected the permissions of a self_managed deployment not to be checked")
+	}
+}
@@ -0,0 +1,226 @@
+package couchbasecapella
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+)
+
+const (
+	credentialTypeDatabase        = "database"
+	credentialTypeAPIKey          = "api_key"
+	credentialTypeAppEndpointUser = "app_endpoint_user"
+)
+
+// capellaStatement is a creation, rotation or revocation statement of a role.
+// Besides the database credential access list it carries the plugin specific
+// extensions, which Capella never sees.
+type capellaStatement struct {
+	// CredentialType selects what the role issues, database credentials
+	// unless set.
+	CredentialType string `json:"credential_type"`
+
+	Access json.RawMessage `json:"access"`
+
+	// Cluster and Clusters name the clusters, by ID or by name, the
+	// statement applies to instead of cluster_id and cluster_ids.
+	Cluster  string   `json:"cluster"`
+	Clusters []string `json:"clusters"`
+
+	// OrganizationRoles, ProjectRoles and Resources describe the roles of
+	// api_key credentials. ProjectRoles apply to the configured project.
+	OrganizationRoles []string                `json:"organization_roles"`
+	ProjectRoles      []string                `json:"project_roles"`
+	Resources         []CapellaAPIKeyResource `json:"resources"`
+
+	// AppServiceID and AppEndpoint locate the App Endpoint of
+	// app_endpoint_user credentials, AdminChannels and Roles are granted
+	// to the user. In a self_managed deployment Roles are the RBAC roles
+	// of the user, such as data_reader[orders].
+	AppServiceID  string   `json:"app_service_id"`
+	AppEndpoint   string   `json:"app_endpoint"`
+	AdminChannels []string `json:"admin_channels"`
+	Roles         []string `json:"roles"`
+
+	// Groups are the existing RBAC groups a user of a self_managed
+	// deployment joins, instead of or besides being granted Roles.
+	Groups []string `json:"groups"`
+
+	// AllowedCIDR is added to the allowed CIDR list of the target clusters
+	// for the lifetime of the lease. It is a template rendered with the
+	// username metadata, so it can be passed in through the display name.
+	AllowedCIDR string `json:"allowed_cidr"`
+
+	// Canary is run as every new user to check its access.
+	Canary *canaryProbe `json:"canary"`
+}
+
+// parseStatement decodes a role statement.
+func parseStatement(raw string) (*capellaStatement, error) {
+	var stmt capellaStatement
+	err := json.Unmarshal([]byte(raw), &stmt)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse statement %s: %w", raw, err)
+	}
+	switch stmt.CredentialType {
+	case "", credentialTypeDatabase:
+		stmt.CredentialType = credentialTypeDatabase
+		if stmt.Canary != nil {
+			if err := stmt.Canary.validate(); err != nil {
+				return nil, fmt.Errorf("%w in statement %s", err, raw)
+			}
+		}
+	case credentialTypeAPIKey:
+	case credentialTypeAppEndpointUser:
+		if stmt.AppServiceID == "" || stmt.AppEndpoint == "" {
+			return nil, fmt.Errorf("app_service_id and app_endpoint are required for %s credentials in statement %s", credentialTypeAppEndpointUser, raw)
+		}
+	default:
+		return nil, fmt.Errorf("unknown credential_type %q in statement %s", stmt.CredentialType, raw)
+	}
+	return &stmt, nil
+}
+
+// firstStatement parses the first non empty statement of statements, or
+// returns an empty statement if there is none.
+func firstStatement(statements []string) (*capellaStatement, error) {
+	statements = removeEmpty(statements)
+	if len(statements) == 0 {
+		return &capellaStatement{CredentialType: credentialTypeDatabase}, nil
+	}
+	return parseStatement(statements[0])
+}
+
+// checkDeployment rejects the fields of a creation statement that the
+// deployment, or the credential type on Capella, would ignore. A statement
+// that asks for specific access must get it, not the default role.
+func (s *capellaStatement) checkDeployment(deployment string) error {
+	var ignored []string
+	set := func(field string, isSet bool) {
+		if isSet {
+			ignored = append(ignored, field)
+		}
+	}
+
+	var applies string
+	if deployment == deploymentSelfManaged {
+		if s.CredentialType != credentialTypeDatabase {
+			return fmt.Errorf("credential_type %q is not supported by a %s deployment", s.CredentialType, deploymentSelfManaged)
+		}
+		applies = "a " + deploymentSelfManaged + " deployment"
+		set("access", len(s.Access) > 0)
+		set("cluster", s.Cluster != "" || len(s.Clusters) > 0)
+		set("allowed_cidr", s.AllowedCIDR != "")
+	} else {
+		if len(s.Groups) > 0 {
+			return fmt.Errorf("groups are only supported by a %s deployment", deploymentSelfManaged)
+		}
+		applies = s.CredentialType + " credentials"
+		set("access", len(s.Access) > 0 && s.CredentialType != credentialTypeDatabase)
+		set("roles", len(s.Roles) > 0 && s.CredentialType != credentialTypeAppEndpointUser)
+		set("allowed_cidr", s.AllowedCIDR != "" && s.CredentialType != credentialTypeDatabase)
+		set("canary", s.Canary != nil && s.CredentialType != credentialTypeDatabase)
+	}
+	if deployment == deploymentSelfManaged || s.CredentialType != credentialTypeAPIKey {
+		set("organization_roles", len(s.OrganizationRoles) > 0)
+		set("project_roles", len(s.ProjectRoles) > 0)
+		set("resources", len(s.Resources) > 0)
+	}
+	if deployment == deploymentSelfManaged || s.CredentialType != credentialTypeAppEndpointUser {
+		set("app_service_id", s.AppServiceID != "")
+		set("app_endpoint", s.AppEndpoint != "")
+		set("admin_channels", len(s.AdminChannels) > 0)
+	}
+	if len(ignored) > 0 {
+		return fmt.Errorf("%s cannot be set for %s", strings.Join(ignored, ", "), applies)
+	}
+	return nil
+}
+
+// clusterSelectors returns the clusters named by the statement.
+func (s *capellaStatement) clusterSelectors() []string {
+	return parseClusterIDs(s.Cluster, s.Clusters)
+}
+
+// columnarPrivileges are the privileges a Columnar access statement can grant.
+var columnarPrivileges = []string{
+	"database_create", "database_drop",
+	"scope_create", "scope_drop",
+	"collection_create", "collection_drop", "collection_select", "collection_insert",
+	"collection_upsert", "collection_delete", "collection_analyze",
+	"view_create", "view_drop", "view_select",
+	"index_create", "index_drop",
+	"synonym_create", "synonym_drop",
+	"function_create", "function_drop", "function_execute",
+	"link_create", "link_drop", "link_alter", "link_connect", "link_disconnect",
+	"link_copy_to", "link_copy_from", "link_describe",
+}
+
+// accessEntry is one entry of the access list of a database credential.
+type accessEntry struct {
+	Privileges []string                   `json:"privileges"`
+	Resources  map[string]json.RawMessage `json:"resources"`
+}
+
+// columnarDatabase is a database resource of a Columnar access statement.
+type columnarDatabase struct {
+	Name   string `json:"name"`
+	Scopes []struct {
+		Name        string   `json:"name"`
+		Collections []string `json:"collections"`
+	} `json:"scopes"`
+}
+
+// validateAccess checks that the access list matches the cluster type:
+// Columnar statements grant Columnar privileges on databases, the other
+// cluster types grant privileges on buckets.
+func (s *capellaStatement) validateAccess(clusterType string) error {
+	var entries []accessEntry
+	if len(s.Access) == 0 {
+		return fmt.Errorf("the statement has no access list")
+	}
+	err := json.Unmarshal(s.Access, &entries)
+	if err != nil {
+		return fmt.Errorf("unable to parse the access list: %w", err)
+	}
+
+	for i, entry := range entries {
+		if len(entry.Privileges) == 0 {
+			return fmt.Errorf("access entry %d has no privileges", i)
+		}
+		if clusterType != clusterTypeColumnar {
+			if _, ok := entry.Resources["databases"]; ok {
+				return fmt.Errorf("access entry %d grants privileges on Columnar databases, but cluster_type is %q", i, clusterType)
+			}
+			continue
+		}
+
+		for _, privilege := range entry.Privileges {
+			if !strutil.StrListContains(columnarPrivileges, privilege) {
+				return fmt.Errorf("access entry %d grants %q, which is not a Columnar privilege", i, privilege)
+			}
+		}
+		for resource, raw := range entry.Resources {
+			if resource != "databases" {
+				return fmt.Errorf("access entry %d grants privileges on %q, Columnar access statements can only grant privileges on databases", i, resource)
+			}
+			var databases []columnarDatabase
+			if err := json.Unmarshal(raw, &databases); err != nil {
+				return fmt.Errorf("access entry %d has invalid databases: %w", i, err)
+			}
+			for _, db := range databases {
+				if db.Name == "" {
+					return fmt.Errorf("access entry %d has a database without name", i)
+				}
+				for _, scope := range db.Scopes {
+					if scope.Name == "" {
+						return fmt.Errorf("access entry %d has a scope without name in database %q", i, db.Name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
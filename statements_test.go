@@ -0,0 +1,114 @@
+package couchbasecapella
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCapellaStatement_ValidateAccess(t *testing.T) {
+	tests := map[string]struct {
+		clusterType string
+		statement   string
+		wantErr     bool
+	}{
+		"provisioned buckets": {clusterTypeProvisioned, testCouchbaseCapellaRole, false},
+		"provisioned default": {clusterTypeProvisioned, defaultCouchbaseCapellaUserRole, false},
+		"provisioned databases": {clusterTypeProvisioned,
+			`{"access": [{"privileges": ["collection_select"], "resources": {"databases": [{"name": "sales"}]}}]}`, true},
+		"columnar default": {clusterTypeColumnar, defaultColumnarUserRole, false},
+		"columnar databases": {clusterTypeColumnar,
+			`{"access": [{"privileges": ["collection_select", "view_select"], "resources": {"databases": [{"name": "sales", "scopes": [{"name": "eu", "collections": ["*"]}]}]}}]}`, false},
+		"columnar buckets": {clusterTypeColumnar, testCouchbaseCapellaRole, true},
+		"columnar unknown privilege": {clusterTypeColumnar,
+			`{"access": [{"privileges": ["data_reader"], "resources": {"databases": [{"name": "sales"}]}}]}`, true},
+		"columnar unnamed scope": {clusterTypeColumnar,
+			`{"access": [{"privileges": ["collection_select"], "resources": {"databases": [{"name": "sales", "scopes": [{"collections": ["*"]}]}]}}]}`, true},
+		"no privileges": {clusterTypeProvisioned, `{"access": [{"resources": {"buckets": [{"name": "*"}]}}]}`, true},
+		"no access":     {clusterTypeProvisioned, `{"cluster": "c1"}`, true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			stmt, err := parseStatement(test.statement)
+			if err != nil {
+				t.Fatalf("unexpected parse error: %s", err)
+			}
+			err = stmt.validateAccess(test.clusterType)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected validation result: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseStatement_Canary(t *testing.T) {
+	tests := map[string]struct {
+		statement string
+		want      canaryProbe
+		wantErr   bool
+	}{
+		"defaults": {
+			statement: `{"canary": {"bucket": "orders", "key": "probe"}}`,
+			want:      canaryProbe{Bucket: "orders", Scope: "_default", Collection: "_default", Key: "probe", Operation: "read", Expect: "allowed"},
+		},
+		"upsert denied": {
+			statement: `{"canary": {"bucket": "orders", "scope": "eu", "collection": "archive", "key": "probe", "operation": "upsert", "expect": "denied"}}`,
+			want:      canaryProbe{Bucket: "orders", Scope: "eu", Collection: "archive", Key: "probe", Operation: "upsert", Expect: "denied"},
+		},
+		"no key":            {statement: `{"canary": {"bucket": "orders"}}`, wantErr: true},
+		"unknown operation": {statement: `{"canary": {"bucket": "orders", "key": "probe", "operation": "remove"}}`, wantErr: true},
+		"unknown expect":    {statement: `{"canary": {"bucket": "orders", "key": "probe", "expect": "maybe"}}`, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			stmt, err := parseStatement(test.statement)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if test.wantErr {
+				return
+			}
+			if *stmt.Canary != test.want {
+				t.Fatalf("expected canary %+v, got %+v", test.want, *stmt.Canary)
+			}
+		})
+	}
+}
+
+func TestCapellaStatement_CheckDeployment(t *testing.T) {
+	tests := map[string]struct {
+		deployment string
+		statement  string
+		wantErr    string
+	}{
+		"capella access":           {deployment: deploymentCapella, statement: `{"access": [{"privileges": ["data_reader"]}]}`},
+		"capella roles":            {deployment: deploymentCapella, statement: `{"roles": ["data_reader[*]"]}`, wantErr: "roles cannot be set for database credentials"},
+		"capella groups":           {deployment: deploymentCapella, statement: `{"groups": ["readers"]}`, wantErr: "groups are only supported"},
+		"api key project roles":    {deployment: deploymentCapella, statement: `{"credential_type": "api_key", "project_roles": ["projectViewer"]}`},
+		"api key access":           {deployment: deploymentCapella, statement: `{"credential_type": "api_key", "access": [{"privileges": ["data_reader"]}]}`, wantErr: "access cannot be set for api_key credentials"},
+		"app endpoint roles":       {deployment: deploymentCapella, statement: `{"credential_type": "app_endpoint_user", "app_service_id": "svc", "app_endpoint": "orders", "roles": ["reader"]}`},
+		"self managed roles":       {deployment: deploymentSelfManaged, statement: `{"roles": ["data_reader[orders]"], "groups": ["readers"]}`},
+		"self managed access":      {deployment: deploymentSelfManaged, statement: `{"access": [{"privileges": ["data_reader"]}], "cluster": "c1"}`, wantErr: "access, cluster cannot be set for a self_managed deployment"},
+		"self managed api key":     {deployment: deploymentSelfManaged, statement: `{"credential_type": "api_key"}`, wantErr: "not supported by a self_managed deployment"},
+		"self managed app channel": {deployment: deploymentSelfManaged, statement: `{"admin_channels": ["orders"]}`, wantErr: "admin_channels cannot be set"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			stmt, err := parseStatement(test.statement)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			err = stmt.checkDeployment(test.deployment)
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			case test.wantErr != "" && err == nil:
+				t.Fatalf("expected error containing %q", test.wantErr)
+			case test.wantErr != "" && !strings.Contains(err.Error(), test.wantErr):
+				t.Fatalf("expected error containing %q, got %q", test.wantErr, err)
+			}
+		})
+	}
+}
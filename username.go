@@ -0,0 +1,249 @@
+package couchbasecapella
+
+import (
+	"fmt"
+	"strings"
+	"text/template/parse"
+	"unicode/utf8"
+
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+)
+
+const (
+	maxUsernameLength          = 128
+	defaultUsernameReplacement = "_"
+
+	usernameCaseUpper    = "upper"
+	usernameCaseLower    = "lower"
+	usernameCasePreserve = "preserve"
+)
+
+// usernameRules turn a rendered username template into a name Capella
+// accepts: letters, digits, underscores, hyphens and dots, at most 128 long.
+type usernameRules struct {
+	// caseMode is the case of the username: upper, lower or preserve.
+	caseMode string
+
+	// replacement replaces every character Capella does not accept. It may
+	// be empty to drop those characters.
+	replacement string
+
+	// collapse merges runs of replacements into one.
+	collapse bool
+}
+
+// parseUsernameRules reads username_case, username_replacement and
+// username_collapse.
+func parseUsernameRules(config map[string]interface{}) (usernameRules, error) {
+	rules := usernameRules{caseMode: usernameCaseUpper, replacement: defaultUsernameReplacement, collapse: true}
+
+	if _, ok := config["username_case"]; ok {
+		caseMode, err := strutil.GetString(config, "username_case")
+		if err != nil {
+			return rules, fmt.Errorf("failed to retrieve username_case: %w", err)
+		}
+		switch caseMode {
+		case "":
+		case usernameCaseUpper, usernameCaseLower, usernameCasePreserve:
+			rules.caseMode = caseMode
+		default:
+			return rules, fmt.Errorf("username_case must be one of %q, %q or %q", usernameCaseUpper, usernameCaseLower, usernameCasePreserve)
+		}
+	}
+
+	if _, ok := config["username_replacement"]; ok {
+		replacement, err := strutil.GetString(config, "username_replacement")
+		if err != nil {
+			return rules, fmt.Errorf("failed to retrieve username_replacement: %w", err)
+		}
+		if strings.IndexFunc(replacement, func(r rune) bool { return !validUsernameRune(r) }) >= 0 {
+			return rules, fmt.Errorf("username_replacement %q contains characters Capella does not accept in usernames", replacement)
+		}
+		rules.replacement = replacement
+	}
+	if raw, ok := config["username_collapse"]; ok {
+		collapse, err := parseutil.ParseBool(raw)
+		if err != nil {
+			return rules, fmt.Errorf("invalid username_collapse: %w", err)
+		}
+		rules.collapse = collapse
+	}
+	return rules, nil
+}
+
+// validUsernameRune reports whether Capella accepts r in a username.
+func validUsernameRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case r == '_', r == '-', r == '.':
+		return true
+	}
+	return false
+}
+
+// apply brings a rendered username into the configured case and sanitizes it.
+func (u usernameRules) apply(username string) string {
+	switch u.caseMode {
+	case usernameCaseLower:
+		username = strings.ToLower(username)
+	case usernameCasePreserve:
+	default:
+		username = strings.ToUpper(username)
+	}
+	return u.sanitize(username)
+}
+
+// sanitize replaces the characters of username Capella does not accept.
+func (u usernameRules) sanitize(username string) string {
+	var b strings.Builder
+	replaced := false
+	for _, r := range username {
+		if validUsernameRune(r) {
+			b.WriteRune(r)
+			replaced = false
+			continue
+		}
+		if u.collapse && replaced {
+			continue
+		}
+		b.WriteString(u.replacement)
+		replaced = true
+	}
+	return b.String()
+}
+
+// validateUsername checks username against the Capella naming rules. The
+// error shows the username with the offending characters in brackets.
+func validateUsername(username string) error {
+	if username == "" {
+		return fmt.Errorf("the username is empty")
+	}
+
+	var marked strings.Builder
+	invalid := false
+	for _, r := range username {
+		if validUsernameRune(r) {
+			marked.WriteRune(r)
+			continue
+		}
+		fmt.Fprintf(&marked, "[%c]", r)
+		invalid = true
+	}
+	if invalid {
+		return fmt.Errorf("username %q contains characters Capella does not accept, only letters, digits, '_', '-' and '.' are allowed: %s",
+			username, marked.String())
+	}
+
+	if n := utf8.RuneCountInString(username); n > maxUsernameLength {
+		return fmt.Errorf("username is %d characters long, Capella accepts at most %d: %s[%s]",
+			n, maxUsernameLength, username[:maxUsernameLength], username[maxUsernameLength:])
+	}
+	return nil
+}
+
+// usernameSamples is the metadata username_template is rendered with at
+// Initialize: a plain token, a long display name, an OIDC display name with
+// unicode and an empty role name.
+var usernameSamples = []dbplugin.UsernameMetadata{
+	{DisplayName: "token", RoleName: "readonly"},
+	{DisplayName: strings.Repeat("long-display-name-", 10), RoleName: "readonly"},
+	{DisplayName: "oidc-José Müller@example.com", RoleName: "readonly"},
+	{DisplayName: "token", RoleName: ""},
+}
+
+// uniqueFunctions are the template functions that make every rendering of a
+// username template unique, by chance or over time.
+var uniqueFunctions = []string{"random", "uuid", "unix_time", "unix_time_millis", "timestamp"}
+
+// templateFunctions returns the names of the functions rawTemplate calls.
+func templateFunctions(rawTemplate string) (map[string]bool, error) {
+	tree := parse.New("username_template")
+	tree.Mode = parse.SkipFuncCheck
+	_, err := tree.Parse(rawTemplate, "", "", map[string]*parse.Tree{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse username_template: %w", err)
+	}
+
+	functions := map[string]bool{}
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.IdentifierNode:
+			functions[n.Ident] = true
+		}
+	}
+	walk(tree.Root)
+	return functions, nil
+}
+
+// validateUsernameTemplate renders the username template for every sample
+// and rejects it if a rendering is not a valid username, or if the template
+// calls none of the functions that make usernames unique.
+func (c *CouchbaseCapellaDB) validateUsernameTemplate(rawTemplate string) error {
+	// Render with sample cluster and project names, the connection is not
+	// configured yet.
+	producer, err := c.newUsernameProducer(rawTemplate, true)
+	if err != nil {
+		return fmt.Errorf("unable to initialize username template: %w", err)
+	}
+	for _, sample := range usernameSamples {
+		username, err := c.renderUsername(producer, sample)
+		if err != nil {
+			return fmt.Errorf("username_template fails for display name %q and role name %q: %w", sample.DisplayName, sample.RoleName, err)
+		}
+		c.logger.Debug("rendered sample username", "username", username)
+	}
+
+	functions, err := templateFunctions(rawTemplate)
+	if err != nil {
+		return err
+	}
+	unique := false
+	for _, name := range uniqueFunctions {
+		unique = unique || functions[name]
+	}
+	if !unique {
+		return fmt.Errorf("usernames are not unique, username_template calls none of %s; add random or unix_time",
+			strings.Join(uniqueFunctions, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package couchbasecapella
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/template"
+)
+
+const (
+	// shortHashLength is the number of hex digits short_hash returns.
+	shortHashLength = 8
+
+	sampleClusterName = "sample-cluster"
+	sampleProjectName = "sample-project"
+)
+
+// usernameTemplateFunctions returns the functions username_template may use
+// on top of the Vault defaults. cluster_name names the configured cluster.
+// With sample set, cluster_name and project_name return fixed names instead
+// of asking Capella, once the configuration allows them, so templates can be
+// checked at Initialize without I/O. Without a configured cluster the roles
+// name the cluster, so cluster_name is only checked for each request.
+func (c *CouchbaseCapellaDB) usernameTemplateFunctions(sample bool) []template.Opt {
+	clusterName := func() (string, error) { return c.clusterName(c.clusterIDs) }
+	projectName := c.projectName
+	if sample {
+		clusterName = func() (string, error) {
+			if len(c.clusterIDs) > 0 || c.Deployment == deploymentSelfManaged {
+				if err := c.checkClusterName(c.clusterIDs); err != nil {
+					return "", err
+				}
+			}
+			return sampleClusterName, nil
+		}
+		projectName = func() (string, error) {
+			if err := c.checkProjectName(); err != nil {
+				return "", err
+			}
+			return sampleProjectName, nil
+		}
+	}
+	return []template.Opt{
+		template.Function("sanitize_capella", func(s string) string {
+			return c.usernameRules.sanitize(s)
+		}),
+		template.Function("short_hash", shortHash),
+		template.Function("cluster_name", clusterName),
+		template.Function("project_name", projectName),
+	}
+}
+
+// newUsernameProducer parses username_template with the plugin functions.
+func (c *CouchbaseCapellaDB) newUsernameProducer(rawTemplate string, sample bool) (template.StringTemplate, error) {
+	opts := append([]template.Opt{template.Template(rawTemplate)}, c.usernameTemplateFunctions(sample)...)
+	return template.NewTemplate(opts...)
+}
+
+// shortHash returns a stable hex hash of its arguments, such as the display
+// name and role name, to keep usernames short but distinguishable.
+func shortHash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])[:shortHashLength]
+}
+
+// checkClusterName returns an error unless cluster_name can name the
+// cluster of a user managed on clusterIDs.
+func (c *couchbaseCapellaDBConnectionProducer) checkClusterName(clusterIDs []string) error {
+	switch {
+	case c.Deployment == deploymentSelfManaged:
+		return fmt.Errorf("cluster_name is only available for Capella deployments")
+	case len(clusterIDs) == 0:
+		return fmt.Errorf("cluster_name requires a single target cluster, set cluster_id in the database configuration or cluster in the role statement")
+	case len(clusterIDs) > 1:
+		return fmt.Errorf("cluster_name requires a single target cluster, the user would be created on %d clusters", len(clusterIDs))
+	}
+	return nil
+}
+
+// clusterName returns the name of the single cluster in clusterIDs.
+func (c *couchbaseCapellaDBConnectionProducer) clusterName(clusterIDs []string) (string, error) {
+	if err := c.checkClusterName(clusterIDs); err != nil {
+		return "", err
+	}
+	cluster, err := c.clusterState(c.clustersPath(clusterIDs[0]), false)
+	if err != nil {
+		return "", err
+	}
+	return cluster.Name, nil
+}
+
+// checkProjectName returns an error unless project_name is available.
+func (c *couchbaseCapellaDBConnectionProducer) checkProjectName() error {
+	if c.Deployment == deploymentSelfManaged {
+		return fmt.Errorf("project_name is only available for Capella deployments")
+	}
+	return nil
+}
+
+// projectName returns the name of the configured project. The name is read
+// once and kept until the next Init.
+func (c *couchbaseCapellaDBConnectionProducer) projectName() (string, error) {
+	if err := c.checkProjectName(); err != nil {
+		return "", err
+	}
+
+	c.stateLock.Lock()
+	name := c.cachedProjectName
+	c.stateLock.Unlock()
+	if name != "" {
+		return name, nil
+	}
+
+	// The project is read without holding stateLock, which would stall
+	// every cluster state lookup for the duration of the request.
+	project, err := GetCapellaProject(c.CloudAPIBaseURL, c.OrganizationID, c.ProjectID, c.Username, c.Password)
+	if err != nil {
+		return "", fmt.Errorf("unable to read project %s: %w", c.ProjectID, err)
+	}
+
+	c.stateLock.Lock()
+	c.cachedProjectName = project.Name
+	c.stateLock.Unlock()
+	return project.Name, nil
+}
@@ -0,0 +1,226 @@
+package couchbasecapella
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	dbplugin "github.com/hashicorp/vault/sdk/database/dbplugin/v5"
+)
+
+func TestUsernameRules_Apply(t *testing.T) {
+	tests := map[string]struct {
+		config   map[string]interface{}
+		username string
+		want     string
+	}{
+		"valid":       {username: "V_TOKEN_ROLE_1692391706", want: "V_TOKEN_ROLE_1692391706"},
+		"email":       {username: "V_OIDC-ALICE@EXAMPLE.COM_ROLE", want: "V_OIDC-ALICE_EXAMPLE.COM_ROLE"},
+		"collapsed":   {username: "V_JOHN  DOE_ROLE", want: "V_JOHN_DOE_ROLE"},
+		"unicode":     {username: "V_JOSÉ_RÔLE", want: "V_JOS__R_LE"},
+		"no collapse": {config: map[string]interface{}{"username_collapse": "false"}, username: "V_A  B", want: "V_A__B"},
+		"replacement": {config: map[string]interface{}{"username_replacement": "-"}, username: "V_A B", want: "V_A-B"},
+		"dropped":     {config: map[string]interface{}{"username_replacement": ""}, username: "V_A@B", want: "V_AB"},
+		"lower":       {config: map[string]interface{}{"username_case": "lower"}, username: "V_Token_Role", want: "v_token_role"},
+		"preserve":    {config: map[string]interface{}{"username_case": "preserve"}, username: "V_Token_Role", want: "V_Token_Role"},
+		"upper":       {username: "v_token_role", want: "V_TOKEN_ROLE"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rules, err := parseUsernameRules(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rules.apply(test.username); got != test.want {
+				t.Fatalf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+
+	if _, err := parseUsernameRules(map[string]interface{}{"username_replacement": "@"}); err == nil {
+		t.Fatal("expected an invalid replacement to be rejected")
+	}
+	if _, err := parseUsernameRules(map[string]interface{}{"username_case": "title"}); err == nil {
+		t.Fatal("expected an unknown username_case to be rejected")
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := map[string]struct {
+		username string
+		wantErr  string
+	}{
+		"valid":    {username: "V_TOKEN_ROLE.1-2"},
+		"empty":    {username: "", wantErr: "empty"},
+		"invalid":  {username: "V_A@B C", wantErr: "V_A[@]B[ ]C"},
+		"too long": {username: strings.Repeat("A", 130), wantErr: strings.Repeat("A", 128) + "[AA]"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateUsername(test.username)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCouchbaseCapellaDB_ValidateUsernameTemplate(t *testing.T) {
+	tests := map[string]struct {
+		template string
+		config   map[string]interface{}
+		wantErr  string
+	}{
+		"default":      {template: defaultUserNameTemplate},
+		"random":       {template: `{{.RoleName}}_{{random 8}}`},
+		"time":         {template: `vault_{{.DisplayName | truncate 20}}_{{unix_time}}`},
+		"empty":        {template: `{{if .RoleName}}{{.RoleName}}_{{unix_time}}{{end}}`, wantErr: "the username is empty"},
+		"not unique":   {template: `v_{{.DisplayName | truncate 10}}_{{.RoleName}}`, wantErr: "not unique"},
+		"short random": {template: `{{.RoleName}}_{{random 1}}`},
+		"name in text": {template: `v_unix_time_{{.RoleName}}`, wantErr: "not unique"},
+		"nested":       {template: `{{printf "v_%s_%s" .RoleName (uuid)}}`},
+		"overlong":     {template: `{{.DisplayName}}_{{random 8}}`, wantErr: "Capella accepts at most 128"},
+		"render error": {template: `{{truncate -1 .DisplayName}}`, wantErr: "fails for display name"},
+		"functions":    {template: `{{cluster_name}}_{{project_name}}_{{short_hash .DisplayName .RoleName}}_{{random 4}}`},
+		// The roles name the cluster, cluster_name is checked per request.
+		"cluster name without cluster": {
+			template: `{{cluster_name}}_{{random 8}}`,
+			config:   map[string]interface{}{"cluster_id": ""},
+		},
+		"cluster name of several clusters": {
+			template: `{{cluster_name}}_{{random 8}}`,
+			config:   map[string]interface{}{"cluster_ids": "other"},
+			wantErr:  "single target cluster",
+		},
+		"project name self-managed": {
+			template: `{{project_name}}_{{random 8}}`,
+			config:   map[string]interface{}{"deployment": deploymentSelfManaged, "hosts": "couchbases://db.example.com", "insecure_tls": true},
+			wantErr:  "only available for Capella deployments",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := map[string]interface{}{
+				"username_template": test.template,
+				"organization_id":   "org",
+				"project_id":        "proj",
+				"cluster_id":        "cluster",
+				"username":          "ACCESS",
+				"password":          "SECRET",
+			}
+			for k, v := range test.config {
+				config[k] = v
+			}
+			db := new()
+			db.logger = hclog.NewNullLogger()
+			_, err := db.Initialize(context.Background(), dbplugin.InitializeRequest{Config: config})
+			defer db.Close()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected the template to be accepted, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCouchbaseCapellaDB_UsernameFunctions(t *testing.T) {
+	projectCalls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/organizations/org/projects/proj":
+			projectCalls++
+			w.Write([]byte(`{"id":"proj","name":"Payments Prod"}`))
+		case "/organizations/org/projects/proj/clusters/cluster":
+			w.Write([]byte(`{"id":"cluster","name":"eu-west","currentState":"healthy"}`))
+		case "/organizations/org/projects/proj/clusters":
+			w.Write([]byte(`{"data":[{"id":"cluster","name":"eu-west"},{"id":"other","name":"us-east"}]}`))
+		case "/organizations/org/projects/proj/clusters/other":
+			w.Write([]byte(`{"id":"other","name":"us-east","currentState":"healthy"}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	db := &CouchbaseCapellaDB{
+		couchbaseCapellaDBConnectionProducer: newTestConnectionProducer(srv.URL),
+		logger:                               hclog.NewNullLogger(),
+	}
+	db.usernameRules = usernameRules{caseMode: usernameCasePreserve, replacement: defaultUsernameReplacement, collapse: true}
+
+	tmpl := `{{project_name | sanitize_capella}}.{{cluster_name}}.{{sanitize_capella .DisplayName}}.{{short_hash .DisplayName .RoleName}}`
+	producer, err := db.newUsernameProducer(tmpl, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	db.usernameTemplate = tmpl
+	db.usernameProducer = producer
+	db.usernameFunctions, err = templateFunctions(tmpl)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	metadata := dbplugin.UsernameMetadata{DisplayName: "oidc-jane@example.com", RoleName: "reader"}
+	want := "Payments_Prod.eu-west.oidc-jane_example.com." + shortHash("oidc-jane@example.com", "reader")
+	for i := 0; i < 2; i++ {
+		got, err := db.generateUsername(metadata, &capellaStatement{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+	if projectCalls != 1 {
+		t.Fatalf("expected the project name to be read once, read %d times", projectCalls)
+	}
+
+	// cluster_name names the cluster the role targets.
+	got, err := db.generateUsername(metadata, &capellaStatement{Cluster: "other"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := "Payments_Prod.us-east.oidc-jane_example.com." + shortHash("oidc-jane@example.com", "reader"); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	_, err = db.generateUsername(metadata, &capellaStatement{Clusters: []string{"cluster", "other"}})
+	if err == nil || !strings.Contains(err.Error(), "single target cluster") {
+		t.Fatalf("expected cluster_name to be rejected for several clusters, got %v", err)
+	}
+
+	// Without a configured cluster the statement must name exactly one.
+	db.couchbaseCapellaDBConnectionProducer.clusterIDs = nil
+	_, err = db.generateUsername(metadata, &capellaStatement{})
+	if err == nil || !strings.Contains(err.Error(), "single target cluster") {
+		t.Fatalf("expected cluster_name to be rejected without a target cluster, got %v", err)
+	}
+	got, err = db.generateUsername(metadata, &capellaStatement{Cluster: "other"})
+	if err != nil || !strings.Contains(got, ".us-east.") {
+		t.Fatalf("expected the cluster of the statement to be named, got %q, %v", got, err)
+	}
+
+	if shortHash("a", "bc") == shortHash("ab", "c") {
+		t.Fatalf("short_hash must keep its arguments apart")
+	}
+	if len(shortHash("a")) != shortHashLength {
+		t.Fatalf("expected a hash of %d characters, got %q", shortHashLength, shortHash("a"))
+	}
+}
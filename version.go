@@ -0,0 +1,93 @@
+package couchbasecapella
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+)
+
+// collectionsMinVersion is the first Couchbase Server version with scopes and
+// collections, and therefore with scope and collection level access.
+var collectionsMinVersion = version.Must(version.NewVersion("7.0.0"))
+
+// parseServerVersion parses a Couchbase Server version such as
+// 7.2.4-7070-enterprise, ignoring the build number and edition.
+func parseServerVersion(raw string) (*version.Version, error) {
+	v, err := version.NewVersion(strings.SplitN(strings.TrimSpace(raw), "-", 2)[0])
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse Couchbase Server version %q: %w", raw, err)
+	}
+	return v, nil
+}
+
+// capellaClusterVersion returns the Couchbase Server version of the Capella
+// cluster clusterID, or nil if Capella does not report one.
+func (c *couchbaseCapellaDBConnectionProducer) capellaClusterVersion(clusterID string) (*version.Version, error) {
+	cluster, err := c.clusterState(c.clustersPath(clusterID), false)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.CouchbaseServer.Version == "" {
+		return nil, nil
+	}
+	return parseServerVersion(cluster.CouchbaseServer.Version)
+}
+
+// selfManagedVersion returns the Couchbase Server version of the self-managed
+// cluster.
+func selfManagedVersion(client *CouchbaseRESTClient) (*version.Version, error) {
+	raw, err := client.Version()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the Couchbase Server version: %w", err)
+	}
+	if raw == "" {
+		return nil, nil
+	}
+	return parseServerVersion(raw)
+}
+
+// checkCollectionsSupported fails if v, the version of cluster, predates
+// scope and collection level access. An unknown version is not checked.
+func checkCollectionsSupported(v *version.Version, cluster string) error {
+	if v == nil || !v.LessThan(collectionsMinVersion) {
+		return nil
+	}
+	return fmt.Errorf("the statement grants scope or collection level access, which requires Couchbase Server %s or later, but %s runs %s",
+		collectionsMinVersion, cluster, v)
+}
+
+// usesCollections reports whether the access list of a database credential
+// statement grants privileges on scopes or collections of a bucket.
+func (s *capellaStatement) usesCollections() bool {
+	var entries []accessEntry
+	if err := json.Unmarshal(s.Access, &entries); err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		var buckets []struct {
+			Scopes []json.RawMessage `json:"scopes"`
+		}
+		if err := json.Unmarshal(entry.Resources["buckets"], &buckets); err != nil {
+			continue
+		}
+		for _, bucket := range buckets {
+			if len(bucket.Scopes) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rolesUseCollections reports whether one of the RBAC role specs, such as
+// data_reader[orders:eu:archive], is bound to a scope or collection.
+func rolesUseCollections(roles []string) bool {
+	for _, role := range roles {
+		if i := strings.Index(role, "["); i >= 0 && strings.Contains(role[i:], ":") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package couchbasecapella
+
+import (
+	"testing"
+)
+
+func TestCheckCollectionsSupported(t *testing.T) {
+	tests := map[string]struct {
+		version string
+		wantErr bool
+	}{
+		"6.6":       {"6.6.5-10080-enterprise", true},
+		"7.0 build": {"7.0.0-5302-enterprise", false},
+		"7.2":       {"7.2.4", false},
+		"unknown":   {"", false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if test.version == "" {
+				if err := checkCollectionsSupported(nil, "the cluster"); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			v, err := parseServerVersion(test.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkCollectionsSupported(v, "the cluster")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+		})
+	}
+}
+
+func TestCapellaStatement_UsesCollections(t *testing.T) {
+	tests := map[string]struct {
+		statement string
+		want      bool
+	}{
+		"bucket": {testCouchbaseCapellaRole, false},
+		"scopes": {`{"access": [{"privileges": ["data_reader"], "resources": {"buckets": [{"name": "orders", "scopes": [{"name": "eu"}]}]}}]}`, true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			stmt, err := parseStatement(test.statement)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := stmt.usesCollections(); got != test.want {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestRolesUseCollections(t *testing.T) {
+	if rolesUseCollections([]string{"ro_admin", "data_reader[orders]"}) {
+		t.Fatal("bucket level roles reported as collection level")
+	}
+	if !rolesUseCollections([]string{"data_reader[orders:eu:archive]"}) {
+		t.Fatal("collection level role not detected")
+	}
+}